@@ -0,0 +1,251 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sync"
+)
+
+/*
+Throughout the library, authKey is the literal value of the Authorization
+header sent to Pz.  An Authenticator produces that value, and may be able to
+produce a new one when the old one is rejected.  There are two ways to use
+one: call AuthKey to get an authKey string to pass to the existing
+functions, or install an AuthTransport on the HTTP client and pass an empty
+authKey, in which case the header is filled in on every request, and
+requests rejected with a 401 are retried once with refreshed credentials.
+*/
+
+// Authenticator supplies the Authorization header for requests to Pz.
+type Authenticator interface {
+	// AuthHeader returns the current value of the Authorization header.
+	AuthHeader() (string, error)
+	// Refresh discards the current credentials, so that the next call to
+	// AuthHeader obtains new ones.  It returns an error if the credentials
+	// cannot be refreshed.
+	Refresh() error
+}
+
+// BasicAuthHeader builds the value of a Basic Authorization header for the
+// given user name and password.
+func BasicAuthHeader(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// AuthKey returns the current Authorization header of the Authenticator, for
+// use as the authKey of the other functions in this library.
+func AuthKey(auth Authenticator) (string, error) {
+	header, err := auth.AuthHeader()
+	return header, TraceErr(err)
+}
+
+// APIKeyAuth authenticates with a Piazza API key, sent as the user name of
+// Basic auth with an empty password.  It cannot be refreshed.
+type APIKeyAuth string
+
+// AuthHeader returns the Basic auth header for the key.
+func (key APIKeyAuth) AuthHeader() (string, error) {
+	if key == "" {
+		return "", ErrWithTrace("No API key provided.")
+	}
+	return BasicAuthHeader(string(key), ""), nil
+}
+
+// Refresh always fails, as a fixed API key has nothing to refresh to.
+func (key APIKeyAuth) Refresh() error {
+	return ErrWithTrace("API key authentication cannot be refreshed.")
+}
+
+// PasswordAuth authenticates by exchanging a user name and password for an
+// API key at the gateway's /key endpoint.  The key is fetched on first use,
+// and fetched again after a Refresh.
+type PasswordAuth struct {
+	pzAddr string
+	user   string
+	pass   string
+	lock   sync.Mutex
+	apiKey string
+}
+
+// NewPasswordAuth creates a PasswordAuth for the given gateway and
+// credentials.  No request is made until the key is first needed.
+func NewPasswordAuth(pzAddr, user, pass string) *PasswordAuth {
+	return &PasswordAuth{pzAddr: pzAddr, user: user, pass: pass}
+}
+
+// AuthHeader returns the Basic auth header for the API key, fetching the key
+// first if necessary.
+func (pa *PasswordAuth) AuthHeader() (string, error) {
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+	if pa.apiKey == "" {
+		var respObj KeyResp
+		if _, err := RequestKnownJSON("GET", "", pa.pzAddr+"/key", BasicAuthHeader(pa.user, pa.pass), &respObj); err != nil {
+			return "", TraceErr(err)
+		}
+		if respObj.UUID == "" {
+			return "", ErrWithTrace("Gateway returned no API key for user " + pa.user + ".")
+		}
+		pa.apiKey = respObj.UUID
+	}
+	return BasicAuthHeader(pa.apiKey, ""), nil
+}
+
+// Refresh discards the current API key.
+func (pa *PasswordAuth) Refresh() error {
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+	pa.apiKey = ""
+	return nil
+}
+
+// BearerAuth authenticates with a bearer token.  If it was given a token
+// source, the source is used to obtain the first token and to replace it on
+// Refresh.  Otherwise, the token is fixed.
+type BearerAuth struct {
+	source func() (string, error)
+	lock   sync.Mutex
+	token  string
+}
+
+// NewBearerAuth creates a BearerAuth starting with the given token, which
+// may be empty if source is not nil.
+func NewBearerAuth(token string, source func() (string, error)) *BearerAuth {
+	return &BearerAuth{source: source, token: token}
+}
+
+// AuthHeader returns the Bearer auth header for the current token,
+// obtaining one from the source first if necessary.
+func (ba *BearerAuth) AuthHeader() (string, error) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+	if ba.token == "" {
+		if ba.source == nil {
+			return "", ErrWithTrace("No bearer token provided.")
+		}
+		token, err := ba.source()
+		if err != nil {
+			return "", TraceErr(err)
+		}
+		if token == "" {
+			return "", ErrWithTrace("Token source returned an empty token.")
+		}
+		ba.token = token
+	}
+	return "Bearer " + ba.token, nil
+}
+
+// Refresh discards the current token.  It fails if there is no source to
+// obtain a new one from.
+func (ba *BearerAuth) Refresh() error {
+	if ba.source == nil {
+		return ErrWithTrace("Bearer token has no source, and cannot be refreshed.")
+	}
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+	ba.token = ""
+	return nil
+}
+
+// AuthTransport is an http.RoundTripper that fills in the Authorization
+// header of any request that lacks one from its Authenticator.  If such a
+// request is rejected with a 401, the credentials are refreshed and the
+// request retried once, provided its body can be replayed.  When several
+// requests are rejected at once, only the first refreshes the credentials,
+// and the rest retry with what it obtained.  Requests that already carry an
+// Authorization header are passed through untouched.
+type AuthTransport struct {
+	Auth Authenticator
+	Base http.RoundTripper // http.DefaultTransport if nil
+
+	refreshLock sync.Mutex
+}
+
+// NewAuthClient returns an http.Client that authenticates through the given
+// Authenticator, on top of the transport of the given client (or
+// HTTPClient() if nil).  It is intended for use with SetHTTPClient.  Later
+// calls to SetTLSOptions keep the authentication, swapping in a transport
+// with the new TLS settings underneath it.
+func NewAuthClient(auth Authenticator, base *http.Client) *http.Client {
+	if base == nil {
+		base = HTTPClient()
+	}
+	client := *base
+	client.Transport = &AuthTransport{Auth: auth, Base: base.Transport}
+	return &client
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get("Authorization") != "" {
+		return base.RoundTrip(req)
+	}
+
+	authReq, err := t.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := base.RoundTrip(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	if t.refresh(authReq.Header.Get("Authorization")) != nil {
+		return resp, nil
+	}
+
+	retryReq, err := t.authorize(req)
+	if err != nil {
+		return resp, nil
+	}
+	if req.GetBody != nil {
+		if retryReq.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	metrics().RequestRetried(req.Method, metricEndpoint(req.URL.String()))
+	return base.RoundTrip(retryReq)
+}
+
+// refresh refreshes the credentials, unless they have already been
+// refreshed since the given header was rejected.
+func (t *AuthTransport) refresh(rejected string) error {
+	t.refreshLock.Lock()
+	defer t.refreshLock.Unlock()
+	if current, err := t.Auth.AuthHeader(); err == nil && current != rejected {
+		return nil
+	}
+	return t.Auth.Refresh()
+}
+
+// authorize returns a copy of the request with the Authorization header set.
+func (t *AuthTransport) authorize(req *http.Request) (*http.Request, error) {
+	header, err := t.Auth.AuthHeader()
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", header)
+	return authReq, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAuthenticators(t *testing.T) {
+	header, err := AuthKey(APIKeyAuth("my-key"))
+	if err != nil || header != "Basic bXkta2V5Og==" {
+		t.Errorf(`TestAuthenticators: bad API key header "%s", %v`, header, err)
+	}
+	if APIKeyAuth("my-key").Refresh() == nil {
+		t.Error(`TestAuthenticators: API key refreshed.`)
+	}
+	if _, err = APIKeyAuth("").AuthHeader(); err == nil {
+		t.Error(`TestAuthenticators: passed on empty API key.`)
+	}
+
+	SetMockClient([]string{`{"type":"key", "uuid":"key1"}`, `{"type":"key", "uuid":"key2"}`}, 200)
+	pa := NewPasswordAuth("http://testURL.net", "user", "pass")
+	if header, err = pa.AuthHeader(); err != nil || header != BasicAuthHeader("key1", "") {
+		t.Errorf(`TestAuthenticators: bad password header "%s", %v`, header, err)
+	}
+	if header, err = pa.AuthHeader(); err != nil || header != BasicAuthHeader("key1", "") {
+		t.Errorf(`TestAuthenticators: password key not cached: "%s", %v`, header, err)
+	}
+	if err = pa.Refresh(); err != nil {
+		t.Error(`TestAuthenticators: password refresh failed: ` + err.Error())
+	}
+	if header, err = pa.AuthHeader(); err != nil || header != BasicAuthHeader("key2", "") {
+		t.Errorf(`TestAuthenticators: password key not refreshed: "%s", %v`, header, err)
+	}
+
+	tokens := []string{"tok1", "tok2"}
+	ba := NewBearerAuth("", func() (string, error) {
+		if len(tokens) == 0 {
+			return "", errors.New("out of tokens")
+		}
+		tok := tokens[0]
+		tokens = tokens[1:]
+		return tok, nil
+	})
+	if header, err = ba.AuthHeader(); err != nil || header != "Bearer tok1" {
+		t.Errorf(`TestAuthenticators: bad bearer header "%s", %v`, header, err)
+	}
+	ba.Refresh()
+	if header, err = ba.AuthHeader(); err != nil || header != "Bearer tok2" {
+		t.Errorf(`TestAuthenticators: bearer token not refreshed: "%s", %v`, header, err)
+	}
+	if NewBearerAuth("fixed", nil).Refresh() == nil {
+		t.Error(`TestAuthenticators: fixed bearer token refreshed.`)
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	var seen []string
+	var bodies []string
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header := req.Header.Get("Authorization")
+		seen = append(seen, header)
+		if req.Body != nil {
+			byts, _ := ioutil.ReadAll(req.Body)
+			bodies = append(bodies, string(byts))
+		}
+		status := http.StatusOK
+		if header == "Bearer stale" {
+			status = http.StatusUnauthorized
+		}
+		return &http.Response{StatusCode: status, Header: make(http.Header), Request: req, Body: GetMockReadCloser("{}")}, nil
+	})
+
+	tokens := []string{"stale", "fresh"}
+	auth := NewBearerAuth("", func() (string, error) {
+		tok := tokens[0]
+		tokens = tokens[1:]
+		return tok, nil
+	})
+	SetHTTPClient(NewAuthClient(auth, &http.Client{Transport: base}))
+	defer SetHTTPClient(nil)
+
+	resp, err := SubmitSinglePart("POST", `{"a":1}`, "http://testURL.net/data", "")
+	if err != nil {
+		t.Fatal(`TestAuthTransport: request failed: ` + err.Error())
+	}
+	resp.Body.Close()
+	if len(seen) != 2 || seen[0] != "Bearer stale" || seen[1] != "Bearer fresh" {
+		t.Errorf(`TestAuthTransport: bad headers sent: %v`, seen)
+	}
+	if len(bodies) != 2 || bodies[1] != `{"a":1}` {
+		t.Errorf(`TestAuthTransport: body not replayed: %v`, bodies)
+	}
+
+	seen = nil
+	resp, err = SubmitSinglePart("GET", "", "http://testURL.net/data", "Basic explicit")
+	if err != nil {
+		t.Fatal(`TestAuthTransport: explicit request failed: ` + err.Error())
+	}
+	resp.Body.Close()
+	if len(seen) != 1 || seen[0] != "Basic explicit" {
+		t.Errorf(`TestAuthTransport: explicit header overridden: %v`, seen)
+	}
+}
+
+func TestAuthTransportConcurrent(t *testing.T) {
+	const callers = 5
+	var (
+		lock      sync.Mutex
+		keyCalls  int
+		arrived   sync.WaitGroup
+		callersWg sync.WaitGroup
+	)
+	arrived.Add(callers)
+	stale := BasicAuthHeader("key1", "")
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, status := "{}", http.StatusOK
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/key"):
+			lock.Lock()
+			keyCalls++
+			body = `{"type":"key", "uuid":"key` + strconv.Itoa(keyCalls) + `"}`
+			lock.Unlock()
+		case req.Header.Get("Authorization") == stale:
+			// every caller is rejected before any of them refreshes
+			arrived.Done()
+			arrived.Wait()
+			status = http.StatusUnauthorized
+		}
+		return &http.Response{StatusCode: status, Header: make(http.Header), Request: req, Body: GetMockReadCloser(body)}, nil
+	})
+
+	pa := NewPasswordAuth("http://testURL.net", "user", "pass")
+	SetHTTPClient(NewAuthClient(pa, &http.Client{Transport: base}))
+	defer SetHTTPClient(nil)
+	if header, err := pa.AuthHeader(); err != nil || header != stale {
+		t.Fatalf(`TestAuthTransportConcurrent: bad first key "%s", %v`, header, err)
+	}
+
+	for i := 0; i < callers; i++ {
+		callersWg.Add(1)
+		go func() {
+			defer callersWg.Done()
+			if resp, err := SubmitSinglePart("GET", "", "http://testURL.net/data", ""); err != nil {
+				t.Error(`TestAuthTransportConcurrent: request failed: ` + err.Error())
+			} else {
+				resp.Body.Close()
+			}
+		}()
+	}
+	callersWg.Wait()
+	if keyCalls != 2 {
+		t.Errorf(`TestAuthTransportConcurrent: key fetched %d times, expected 2.`, keyCalls)
+	}
+}
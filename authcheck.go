@@ -0,0 +1,197 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+An AuthChecker verifies the Authorization headers of calls coming in to a
+service.  A header is accepted if it matches one of a fixed set of keys, or
+if the Pz gateway accepts it (as per TestPiazzaAuth).  Headers the gateway
+has accepted are cached for a while, so that not every call costs a round
+trip.  Headers it has rejected are cached for a shorter while, so that a
+client retrying a bad key does not turn into a flood of calls to the
+gateway.  Only hashes of the headers are kept.
+*/
+
+// DefaultAuthCacheTTL is how long an AuthChecker trusts a header that the
+// gateway has accepted.
+const DefaultAuthCacheTTL = 5 * time.Minute
+
+// DefaultAuthRejectTTL is how long an AuthChecker refuses a header that the
+// gateway has rejected without asking it again.
+const DefaultAuthRejectTTL = 10 * time.Second
+
+// authCacheSweep is the cache size beyond which expired entries are swept.
+const authCacheSweep = 1000
+
+// AuthChecker verifies inbound Authorization headers against a set of
+// static keys and/or the Pz gateway.
+type AuthChecker struct {
+	pzAddr    string
+	keys      [][]byte
+	cacheTTL  time.Duration
+	rejectTTL time.Duration
+	verify    func(pzGateway, auth string) error
+
+	lock  sync.Mutex
+	cache map[[sha256.Size]byte]authEntry
+}
+
+// authEntry is a cached gateway verdict on a header.
+type authEntry struct {
+	expiry   time.Time
+	accepted bool
+}
+
+// NewAuthChecker creates an AuthChecker accepting the given static keys and,
+// if pzAddr is not empty, anything the gateway at pzAddr accepts.  Each key
+// may be either a full Authorization header or a bare Pz API key, which is
+// matched in its Basic auth form.
+func NewAuthChecker(pzAddr string, keys ...string) *AuthChecker {
+	ac := &AuthChecker{
+		pzAddr:    pzAddr,
+		cacheTTL:  DefaultAuthCacheTTL,
+		rejectTTL: DefaultAuthRejectTTL,
+		verify:    TestPiazzaAuth,
+		cache:     make(map[[sha256.Size]byte]authEntry)}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		header, _ := APIKeyAuth(key).AuthHeader()
+		ac.keys = append(ac.keys, []byte(key), []byte(header))
+	}
+	return ac
+}
+
+// SetCacheTTL sets how long headers accepted by the gateway are trusted.
+// Zero disables caching.
+func (ac *AuthChecker) SetCacheTTL(ttl time.Duration) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.cacheTTL = ttl
+	ac.cache = make(map[[sha256.Size]byte]authEntry)
+}
+
+// SetRejectTTL sets how long headers rejected by the gateway are refused
+// without asking it again.  Zero disables caching of rejections.
+func (ac *AuthChecker) SetRejectTTL(ttl time.Duration) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.rejectTTL = ttl
+	ac.cache = make(map[[sha256.Size]byte]authEntry)
+}
+
+// Check verifies the given Authorization header.  If gatewayOnly is set,
+// static keys are not enough, and the header must be accepted by the
+// gateway.  Failures are returned as 401 HTTPErrors.
+func (ac *AuthChecker) Check(authHeader string, gatewayOnly bool) error {
+	if authHeader == "" {
+		return &HTTPError{Status: http.StatusUnauthorized, Message: "Authorization required."}
+	}
+
+	if !gatewayOnly {
+		for _, key := range ac.keys {
+			if subtle.ConstantTimeCompare(key, []byte(authHeader)) == 1 {
+				return nil
+			}
+		}
+	}
+
+	if ac.pzAddr == "" {
+		return &HTTPError{Status: http.StatusUnauthorized, Message: "Authorization rejected."}
+	}
+	rejected := &HTTPError{Status: http.StatusUnauthorized, Message: "Authorization rejected by Pz gateway."}
+	sum := sha256.Sum256([]byte(authHeader))
+	if accepted, ok := ac.cached(sum); ok {
+		if !accepted {
+			return rejected
+		}
+		return nil
+	}
+	if err := ac.verify(ac.pzAddr, authHeader); err != nil {
+		// only the gateway's own refusals are remembered, not outages
+		if httpErr, ok := err.(*HTTPError); ok &&
+			(httpErr.Status == http.StatusUnauthorized || httpErr.Status == http.StatusForbidden) {
+			ac.remember(sum, false)
+		}
+		return rejected
+	}
+	ac.remember(sum, true)
+	return nil
+}
+
+// cached returns the cached verdict on the header, if there is one.
+func (ac *AuthChecker) cached(sum [sha256.Size]byte) (accepted, ok bool) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	entry, ok := ac.cache[sum]
+	if ok && time.Now().After(entry.expiry) {
+		delete(ac.cache, sum)
+		return false, false
+	}
+	return entry.accepted, ok
+}
+
+func (ac *AuthChecker) remember(sum [sha256.Size]byte, accepted bool) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ttl := ac.cacheTTL
+	if !accepted {
+		ttl = ac.rejectTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	if len(ac.cache) >= authCacheSweep {
+		for key, entry := range ac.cache {
+			if now.After(entry.expiry) {
+				delete(ac.cache, key)
+			}
+		}
+	}
+	ac.cache[sum] = authEntry{expiry: now.Add(ttl), accepted: accepted}
+}
+
+// RequireAuth returns middleware that enforces the authentication demanded
+// by the given service metadata.  If neither CredReq nor PreAuthReq is set,
+// all requests pass.  If CredReq is set, requests must carry a header
+// accepted by Check.  If PreAuthReq is set, that header must be accepted by
+// the gateway itself.  CORS preflight requests, which never carry
+// credentials, are always passed along.
+func (ac *AuthChecker) RequireAuth(rMeta ResMeta) Middleware {
+	required := rMeta.CredReq || rMeta.PreAuthReq
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			isPreflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+			if required && !isPreflight {
+				if err := ac.Check(r.Header.Get("Authorization"), rMeta.PreAuthReq); err != nil {
+					w.Header().Set("WWW-Authenticate", `Basic realm="pzsvc"`)
+					WriteError(w, err)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAuthChecker(t *testing.T) {
+	gatewayCalls := 0
+	ac := NewAuthChecker("http://testURL.net", "staticKey")
+	ac.verify = func(pzGateway, auth string) error {
+		gatewayCalls++
+		switch auth {
+		case "Basic good":
+			return nil
+		case "Basic bad":
+			return &HTTPError{Status: http.StatusUnauthorized, Message: "rejected"}
+		}
+		return errors.New("gateway down")
+	}
+
+	if err := ac.Check(BasicAuthHeader("staticKey", ""), false); err != nil {
+		t.Error(`TestAuthChecker: static API key rejected: ` + err.Error())
+	}
+	if err := ac.Check("staticKey", false); err != nil {
+		t.Error(`TestAuthChecker: static header rejected: ` + err.Error())
+	}
+	if gatewayCalls != 0 {
+		t.Errorf(`TestAuthChecker: gateway called %d times for static keys.`, gatewayCalls)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := ac.Check("Basic good", false); err != nil {
+			t.Error(`TestAuthChecker: gateway-approved header rejected: ` + err.Error())
+		}
+	}
+	if gatewayCalls != 1 {
+		t.Errorf(`TestAuthChecker: approved header not cached; gateway called %d times.`, gatewayCalls)
+	}
+	gatewayCalls = 0
+	for i := 0; i < 3; i++ {
+		if err := ac.Check("Basic bad", false); err == nil {
+			t.Error(`TestAuthChecker: passed on rejected header.`)
+		}
+		if err := ac.Check("Basic other", false); err == nil {
+			t.Error(`TestAuthChecker: passed with gateway down.`)
+		}
+	}
+	if gatewayCalls != 4 {
+		t.Errorf(`TestAuthChecker: expected the rejection alone to be cached; gateway called %d times.`, gatewayCalls)
+	}
+	if err := ac.Check("", false); err == nil {
+		t.Error(`TestAuthChecker: passed on missing header.`)
+	}
+
+	ac.SetCacheTTL(0)
+	ac.SetRejectTTL(0)
+	gatewayCalls = 0
+	ac.Check("Basic good", false)
+	ac.Check("Basic good", false)
+	ac.Check("Basic bad", false)
+	ac.Check("Basic bad", false)
+	if gatewayCalls != 4 {
+		t.Errorf(`TestAuthChecker: cache not disabled; gateway called %d times.`, gatewayCalls)
+	}
+	if err := ac.Check("staticKey", true); err == nil {
+		t.Error(`TestAuthChecker: static key accepted when gateway required.`)
+	}
+
+	if err := NewAuthChecker("", "staticKey").Check("Basic good", false); err == nil {
+		t.Error(`TestAuthChecker: passed without gateway or matching key.`)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	ac := NewAuthChecker("", "staticKey")
+	handler := func(w http.ResponseWriter, r *http.Request) error { return nil }
+
+	rt := NewRouter(ac.RequireAuth(ResMeta{CredReq: true}))
+	rt.HandleFunc("/job", handler)
+	if rec := serveTest(rt, "POST", "/job", "", nil); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf(`TestRequireAuth: unauthenticated request gave %d.`, rec.Code)
+	}
+	if rec := serveTest(rt, "POST", "/job", "", map[string]string{"Authorization": BasicAuthHeader("staticKey", "")}); rec.Code != http.StatusOK {
+		t.Errorf(`TestRequireAuth: authenticated request gave %d: %s`, rec.Code, rec.Body.String())
+	}
+	preflight := map[string]string{"Origin": "https://a.com", "Access-Control-Request-Method": "POST"}
+	if rec := serveTest(rt, "OPTIONS", "/job", "", preflight); rec.Code != http.StatusOK {
+		t.Errorf(`TestRequireAuth: preflight gave %d.`, rec.Code)
+	}
+
+	rt = NewRouter(ac.RequireAuth(ResMeta{}))
+	rt.HandleFunc("/job", handler)
+	if rec := serveTest(rt, "POST", "/job", "", nil); rec.Code != http.StatusOK {
+		t.Errorf(`TestRequireAuth: open service gave %d.`, rec.Code)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// BulkIngestOpts holds the settings shared by every file in a BulkIngest
+// call.  FType, PzAddr, SourceName, Version, AuthKey and Props are passed
+// through to IngestWithClass as-is.
+type BulkIngestOpts struct {
+	FS         FileSystem // where the files are read from.  Defaults to the current working directory
+	FType      string
+	PzAddr     string
+	SourceName string
+	Version    string
+	AuthKey    string
+	Props      map[string]string
+	ClassType  *ClassType // classification for each resource.  Defaults to DefaultClassType()
+	Sem        Semaphore  // limits concurrent ingests.  Takes priority over Limit
+	Limit      int        // maximum concurrent ingests when Sem is nil.  Zero or less is unlimited
+	Deploy     bool       // if set, each ingested file is also deployed to GeoServer
+	LGroupID   string     // layer group for deployments.  Created on the first successful ingest if empty and Deploy is set
+}
+
+// BulkIngestResult is the outcome of ingesting (and possibly deploying) a
+// single file as part of a BulkIngest call.
+type BulkIngestResult struct {
+	FileName   string
+	DataID     string
+	Deployment *DeplStrct
+	Err        error
+}
+
+// BulkIngest ingests the given files to Piazza concurrently, to the limits
+// set by opts.Sem or opts.Limit.  The results are in the same order as
+// fNames, and a failure on one file does not stop the others.  If
+// opts.Deploy is set, each successfully ingested file is deployed to
+// GeoServer under a single layer group, the ID of which is returned.  If
+// opts.LGroupID is empty, the group is created when the first file has been
+// ingested, so that a batch with nothing to deploy leaves no empty group
+// behind.  The error return is reserved for failures that prevent the whole
+// batch from running.
+func BulkIngest(fNames []string, opts BulkIngestOpts) ([]BulkIngestResult, string, error) {
+
+	var (
+		fsys    = opts.FS
+		sem     = opts.Sem
+		lGroup  = opts.LGroupID
+		class   = DefaultClassType()
+		results = make([]BulkIngestResult, len(fNames))
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+	)
+
+	if fsys == nil {
+		fsys = DirFS("")
+	}
+	if opts.ClassType != nil {
+		class = *opts.ClassType
+	}
+	if sem == nil && opts.Limit > 0 {
+		sem = NewSemaphore(opts.Limit)
+	}
+	layerGroup := func() (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if lGroup == "" {
+			groupID, err := AddGeoServerLayerGroup(opts.PzAddr, opts.AuthKey)
+			if err != nil {
+				return "", TraceErr(err)
+			}
+			lGroup = groupID
+		}
+		return lGroup, nil
+	}
+
+	for i, fName := range fNames {
+		wg.Add(1)
+		go func(result *BulkIngestResult, fName string) {
+			defer wg.Done()
+			sem.Lock()
+			defer sem.Unlock()
+
+			result.FileName = fName
+			result.DataID, result.Err = bulkIngestOne(fsys, fName, class, opts)
+			if result.Err != nil || !opts.Deploy {
+				return
+			}
+			groupID, err := layerGroup()
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Deployment, result.Err = DeployToGeoServer(result.DataID, groupID, opts.PzAddr, opts.AuthKey)
+		}(&results[i], fName)
+	}
+	wg.Wait()
+
+	return results, lGroup, nil
+}
+
+// bulkIngestOne ingests a single file for BulkIngest.
+func bulkIngestOne(fsys FileSystem, fName string, class ClassType, opts BulkIngestOpts) (string, error) {
+	file, err := fsys.Open(fName)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	defer file.Close()
+
+	fData, err := readIngestData(fName, file)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	return IngestWithClass(filepath.Base(fName), opts.FType, opts.PzAddr, opts.SourceName,
+		opts.Version, opts.AuthKey, class, fData, opts.Props)
+}
+
+// BulkIngestGlob is BulkIngest for every file in opts.FS matching the given
+// pattern, as per filepath.Match.  The pattern is relative to opts.FS.  It
+// fails if nothing matches.
+func BulkIngestGlob(pattern string, opts BulkIngestOpts) ([]BulkIngestResult, string, error) {
+	if opts.FS == nil {
+		opts.FS = DirFS("")
+	}
+	fNames, err := globFS(opts.FS, pattern)
+	if err != nil {
+		return nil, "", TraceErr(err)
+	}
+	if len(fNames) == 0 {
+		return nil, "", ErrWithTrace(`No files matched pattern "` + pattern + `".`)
+	}
+	return BulkIngest(fNames, opts)
+}
+
+// globFS finds the files in fsys matching pattern.  Since FileSystem does
+// not support listing, only the implementations in this package can be
+// searched.
+func globFS(fsys FileSystem, pattern string) ([]string, error) {
+	switch typed := fsys.(type) {
+	case DirFS:
+		root := string(typed)
+		if root == "" {
+			root = "."
+		}
+		if !filepath.IsLocal(pattern) {
+			return nil, ErrWithTrace(`Pattern "` + pattern + `" reaches outside the FileSystem.`)
+		}
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for i, match := range matches {
+			if matches[i], err = filepath.Rel(root, match); err != nil {
+				return nil, err
+			}
+		}
+		return matches, nil
+	case *MemFS:
+		var matches []string
+		for _, name := range typed.Names() {
+			ok, err := filepath.Match(pattern, name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matches = append(matches, name)
+			}
+		}
+		return matches, nil
+	case readOnlyFS:
+		return fs.Glob(typed.fsys, pattern)
+	}
+	return nil, ErrWithTrace("FileSystem does not support file listing.")
+}
@@ -0,0 +1,109 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"testing"
+)
+
+func TestBulkIngest(t *testing.T) {
+	outStrs := []string{
+		`{"Data":{"JobID":"testID1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"testData"}}}`,
+		`{"Data":{"JobID":"testID2"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"testData"}}}`}
+	SetMockClient(outStrs, 250)
+
+	memFS := NewMemFS()
+	memFS.WriteFile("tile1.txt", []byte("tile1"))
+	memFS.WriteFile("tile2.txt", []byte("tile2"))
+	memFS.WriteFile("other.dat", []byte("other"))
+	opts := BulkIngestOpts{
+		FS:         memFS,
+		FType:      "text",
+		PzAddr:     "http://testURL.net",
+		SourceName: "tester",
+		Version:    "0.0",
+		AuthKey:    "testAuthKey",
+		Limit:      1}
+
+	results, _, err := BulkIngestGlob("tile*.txt", opts)
+	if err != nil {
+		t.Fatal(`TestBulkIngest: failed on glob: ` + err.Error())
+	}
+	if len(results) != 2 {
+		t.Fatalf(`TestBulkIngest: expected 2 results, got %d.`, len(results))
+	}
+	for i, fName := range []string{"tile1.txt", "tile2.txt"} {
+		if results[i].FileName != fName {
+			t.Error(`TestBulkIngest: result out of order: "` + results[i].FileName + `"`)
+		}
+		if results[i].Err != nil || results[i].DataID != "testData" {
+			t.Errorf(`TestBulkIngest: bad result for %s: %#v`, fName, results[i])
+		}
+	}
+
+	results, _, err = BulkIngest([]string{"missing.txt"}, opts)
+	if err != nil || len(results) != 1 || results[0].Err == nil {
+		t.Error(`TestBulkIngest: did not report per-file error for missing file.`)
+	}
+
+	if _, _, err = BulkIngestGlob("*.none", opts); err == nil {
+		t.Error(`TestBulkIngest: passed on empty glob.`)
+	}
+}
+
+func TestBulkIngestDeploy(t *testing.T) {
+	deplResp := `{"Data":{"Status":"Success", "Result":{"Deployment":{"DeploymentID":"testDepl"}}}}`
+	outStrs := []string{
+		`{"Data":{"JobID":"testID1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"testData"}}}`,
+		`{"data":{"deploymentGroupId":"testGroup"}}`,
+		`{"Data":{"JobID":"testID2"}}`,
+		deplResp,
+		`{"Data":{"JobID":"testID3"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"testData"}}}`,
+		`{"Data":{"JobID":"testID4"}}`,
+		deplResp}
+	SetMockClient(outStrs, 250)
+
+	memFS := NewMemFS()
+	memFS.WriteFile("tile1.txt", []byte("tile1"))
+	memFS.WriteFile("tile2.txt", []byte("tile2"))
+	opts := BulkIngestOpts{
+		FS:         memFS,
+		FType:      "text",
+		PzAddr:     "http://testURL.net",
+		SourceName: "tester",
+		Version:    "0.0",
+		AuthKey:    "testAuthKey",
+		Limit:      1,
+		Deploy:     true}
+
+	results, lGroup, err := BulkIngest([]string{"missing.txt"}, opts)
+	if err != nil || lGroup != "" || len(results) != 1 || results[0].Err == nil {
+		t.Errorf(`TestBulkIngestDeploy: failed batch gave group "%s", %v`, lGroup, err)
+	}
+
+	results, lGroup, err = BulkIngest([]string{"tile1.txt", "tile2.txt"}, opts)
+	if err != nil || lGroup != "testGroup" {
+		t.Fatalf(`TestBulkIngestDeploy: gave group "%s", %v`, lGroup, err)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Deployment == nil || result.Deployment.DeplID != "testDepl" {
+			t.Errorf(`TestBulkIngestDeploy: bad result for %s: %#v`, result.FileName, result)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"strings"
+	"sync"
+)
+
+// ClassLevel is a classification level, in increasing order of sensitivity.
+type ClassLevel int
+
+// The known classification levels.
+const (
+	ClassUnclassified ClassLevel = iota
+	ClassConfidential
+	ClassSecret
+	ClassTopSecret
+)
+
+var classLevelNames = []string{"UNCLASSIFIED", "CONFIDENTIAL", "SECRET", "TOP SECRET"}
+
+// classLevelAliases maps the other common spellings of each level.
+var classLevelAliases = map[string]ClassLevel{
+	"U":          ClassUnclassified,
+	"C":          ClassConfidential,
+	"S":          ClassSecret,
+	"TS":         ClassTopSecret,
+	"TOP_SECRET": ClassTopSecret,
+	"TOPSECRET":  ClassTopSecret,
+}
+
+var (
+	defaultClass = ClassType{"UNCLASSIFIED"}
+	classCeiling = ClassUnclassified
+	classLock    sync.RWMutex
+)
+
+func (level ClassLevel) String() string {
+	if level < ClassUnclassified || level > ClassTopSecret {
+		return "UNKNOWN"
+	}
+	return classLevelNames[level]
+}
+
+// NewClassType builds the ClassType for the given level and caveats, as a
+// marking of the form "SECRET//NOFORN//REL TO USA, GBR".
+func NewClassType(level ClassLevel, caveats ...string) ClassType {
+	parts := append([]string{level.String()}, caveats...)
+	return ClassType{Classification: strings.Join(parts, "//")}
+}
+
+// ParseClassType splits the marking of a ClassType into its level and
+// caveats.  Level names are case-insensitive and may be abbreviated (U, C,
+// S, TS).  An empty marking is an error, rather than an assumption.
+func ParseClassType(ct ClassType) (ClassLevel, []string, error) {
+	parts := strings.Split(ct.Classification, "//")
+	levelStr := strings.ToUpper(strings.TrimSpace(parts[0]))
+	if levelStr == "" {
+		return 0, nil, ErrWithTrace("No classification given.")
+	}
+
+	level, ok := classLevelAliases[levelStr]
+	if !ok {
+		found := false
+		for i, name := range classLevelNames {
+			if name == levelStr {
+				level, found = ClassLevel(i), true
+				break
+			}
+		}
+		if !found {
+			return 0, nil, ErrWithTrace(`Unknown classification level "` + parts[0] + `".`)
+		}
+	}
+
+	var caveats []string
+	for _, caveat := range parts[1:] {
+		caveat = strings.ToUpper(strings.TrimSpace(caveat))
+		if caveat == "" {
+			return 0, nil, ErrWithTrace(`Empty caveat in classification "` + ct.Classification + `".`)
+		}
+		caveats = append(caveats, caveat)
+	}
+	return level, caveats, nil
+}
+
+// Level returns the classification level of the ClassType, as per
+// ParseClassType.
+func (ct ClassType) Level() (ClassLevel, error) {
+	level, _, err := ParseClassType(ct)
+	return level, err
+}
+
+// ValidateClassType checks that the ClassType is well-formed, and no higher
+// than the current classification ceiling.
+func ValidateClassType(ct ClassType) error {
+	level, _, err := ParseClassType(ct)
+	if err != nil {
+		return TraceErr(err)
+	}
+	if ceiling := ClassCeiling(); level > ceiling {
+		return ErrWithTrace(`Classification "` + ct.Classification + `" exceeds the allowed ceiling of ` + ceiling.String() + `.`)
+	}
+	return nil
+}
+
+// SetClassCeiling sets the highest classification level that this process
+// may mark resources with.  It defaults to UNCLASSIFIED, so services on
+// higher-classification networks must raise it explicitly.
+func SetClassCeiling(level ClassLevel) {
+	classLock.Lock()
+	defer classLock.Unlock()
+	classCeiling = level
+}
+
+// ClassCeiling returns the current classification ceiling.
+func ClassCeiling() ClassLevel {
+	classLock.RLock()
+	defer classLock.RUnlock()
+	return classCeiling
+}
+
+// SetDefaultClassType sets the classification that the library marks
+// resources with when none is given for a specific call - during Ingest and
+// ManageRegistration, for example.  It must pass ValidateClassType.
+func SetDefaultClassType(ct ClassType) error {
+	if err := ValidateClassType(ct); err != nil {
+		return TraceErr(err)
+	}
+	classLock.Lock()
+	defer classLock.Unlock()
+	defaultClass = ct
+	return nil
+}
+
+// DefaultClassType returns the classification that the library marks
+// resources with when none is given.  It starts out as UNCLASSIFIED.
+func DefaultClassType() ClassType {
+	classLock.RLock()
+	defer classLock.RUnlock()
+	return defaultClass
+}
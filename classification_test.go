@@ -0,0 +1,59 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"testing"
+)
+
+func TestParseClassType(t *testing.T) {
+	level, caveats, err := ParseClassType(ClassType{"secret//noforn//REL TO USA, GBR"})
+	if err != nil || level != ClassSecret || len(caveats) != 2 || caveats[0] != "NOFORN" {
+		t.Errorf(`TestParseClassType: bad parse: %v, %v, %v`, level, caveats, err)
+	}
+	if level, _ := (ClassType{"TS"}).Level(); level != ClassTopSecret {
+		t.Error(`TestParseClassType: abbreviation not recognized.`)
+	}
+	for _, bad := range []string{"", "SORTA SECRET", "SECRET//"} {
+		if _, _, err = ParseClassType(ClassType{bad}); err == nil {
+			t.Error(`TestParseClassType: passed on "` + bad + `".`)
+		}
+	}
+	if ct := NewClassType(ClassConfidential, "NOFORN"); ct.Classification != "CONFIDENTIAL//NOFORN" {
+		t.Error(`TestParseClassType: bad marking: ` + ct.Classification)
+	}
+}
+
+func TestClassCeiling(t *testing.T) {
+	defer SetClassCeiling(ClassCeiling())
+	defer SetDefaultClassType(DefaultClassType())
+
+	secret := NewClassType(ClassSecret)
+	if ValidateClassType(secret) == nil || SetDefaultClassType(secret) == nil {
+		t.Error(`TestClassCeiling: SECRET allowed under default ceiling.`)
+	}
+	SetMockClient(nil, 250)
+	if _, err := IngestWithClass("f", "text", "http://testURL.net", "tester", "0.0", "testAuthKey", secret, []byte("f"), nil); err == nil {
+		t.Error(`TestClassCeiling: ingest allowed above ceiling.`)
+	}
+
+	SetClassCeiling(ClassSecret)
+	if err := SetDefaultClassType(secret); err != nil {
+		t.Error(`TestClassCeiling: SECRET refused under SECRET ceiling: ` + err.Error())
+	}
+	if DefaultClassType() != secret {
+		t.Error(`TestClassCeiling: default classification not set.`)
+	}
+}
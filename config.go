@@ -0,0 +1,273 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Services deployed alongside Pz need to find the gateway, their credentials,
+their own public URL and the port to listen on before they can do anything
+else.  LoadConfig gathers these from, in increasing order of precedence: a
+JSON config file, the Cloud Foundry VCAP_SERVICES and VCAP_APPLICATION
+variables, and plain environment variables.
+*/
+
+// The environment variables read by ApplyEnv.
+const (
+	EnvPzAddr  = "PZ_ADDR"
+	EnvAuthKey = "PZ_AUTH"
+	EnvAPIKey  = "PZ_API_KEY"
+	EnvSvcURL  = "SVC_URL"
+	EnvSvcName = "SVC_NAME"
+	EnvPort    = "PORT"
+)
+
+// Config is what a service needs to know to talk to Pz and to serve.
+// AuthKey is the literal Authorization header, as elsewhere in the library.
+// APIKey, if given, is turned into an AuthKey by LoadConfig.
+type Config struct {
+	PzAddr  string `json:"pzAddr,omitempty"`
+	AuthKey string `json:"authKey,omitempty"`
+	APIKey  string `json:"apiKey,omitempty"`
+	SvcURL  string `json:"svcURL,omitempty"`
+	SvcName string `json:"svcName,omitempty"`
+	Port    string `json:"port,omitempty"`
+}
+
+// vcapService is a single bound service instance in VCAP_SERVICES.
+type vcapService struct {
+	Name        string                 `json:"name"`
+	Label       string                 `json:"label"`
+	Tags        []string               `json:"tags"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// vcapApplication is the part of VCAP_APPLICATION that we use.
+type vcapApplication struct {
+	Name   string   `json:"name"`
+	URIs   []string `json:"application_uris"`
+	AppURI []string `json:"uris"`
+}
+
+// LoadConfig builds a Config from the given JSON file (which may be
+// empty, to skip it), then the VCAP variables, then the environment, and
+// validates the result.
+func LoadConfig(fName string) (*Config, error) {
+	var conf Config
+	if fName != "" {
+		if err := conf.ApplyFile(fName); err != nil {
+			return nil, TraceErr(err)
+		}
+	}
+	if err := conf.ApplyVCAP(os.Getenv("VCAP_SERVICES"), os.Getenv("VCAP_APPLICATION")); err != nil {
+		return nil, TraceErr(err)
+	}
+	conf.ApplyEnv()
+	if conf.AuthKey == "" && conf.APIKey != "" {
+		conf.AuthKey, _ = APIKeyAuth(conf.APIKey).AuthHeader()
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, TraceErr(err)
+	}
+	return &conf, nil
+}
+
+// merge copies the non-empty fields of other over those of the Config.
+// AuthKey and APIKey are a single credential: if other sets either, both
+// are replaced, so that a credential from a lower layer never outranks one
+// from a higher layer.
+func (conf *Config) merge(other Config) {
+	if other.AuthKey != "" || other.APIKey != "" {
+		conf.AuthKey, conf.APIKey = other.AuthKey, other.APIKey
+	}
+	for _, pair := range []struct{ dst, src *string }{
+		{&conf.PzAddr, &other.PzAddr},
+		{&conf.SvcURL, &other.SvcURL},
+		{&conf.SvcName, &other.SvcName},
+		{&conf.Port, &other.Port},
+	} {
+		if *pair.src != "" {
+			*pair.dst = *pair.src
+		}
+	}
+}
+
+// ApplyFile overlays the settings in the given JSON file.
+func (conf *Config) ApplyFile(fName string) error {
+	byts, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return ErrWithTrace("Could not read config file: " + err.Error())
+	}
+	var fileConf Config
+	if err = json.Unmarshal(byts, &fileConf); err != nil {
+		return ErrWithTrace(`Config file "` + fName + `" is not valid JSON: ` + err.Error())
+	}
+	conf.merge(fileConf)
+	return nil
+}
+
+// ApplyVCAP overlays the settings found in the given VCAP_SERVICES and
+// VCAP_APPLICATION JSON, either of which may be empty.  The gateway is taken
+// from the first bound service whose name, label or tags mention
+// "pz-gateway" or "piazza", from its "host", "url" or "uri" credential, and
+// the API key from its "apiKey" or "api_key" credential.  The service URL
+// and name come from the application's first route and name.
+func (conf *Config) ApplyVCAP(services, application string) error {
+	var vConf Config
+
+	if services != "" {
+		var svcMap map[string][]vcapService
+		if err := json.Unmarshal([]byte(services), &svcMap); err != nil {
+			return ErrWithTrace("VCAP_SERVICES is not valid JSON: " + err.Error())
+		}
+		if svc := findPzService(svcMap); svc != nil {
+			vConf.PzAddr = withScheme(credString(svc.Credentials, "host", "url", "uri"))
+			vConf.APIKey = credString(svc.Credentials, "apiKey", "api_key")
+		}
+	}
+
+	if application != "" {
+		var app vcapApplication
+		if err := json.Unmarshal([]byte(application), &app); err != nil {
+			return ErrWithTrace("VCAP_APPLICATION is not valid JSON: " + err.Error())
+		}
+		uris := app.URIs
+		if len(uris) == 0 {
+			uris = app.AppURI
+		}
+		if len(uris) != 0 {
+			vConf.SvcURL = withScheme(uris[0])
+		}
+		vConf.SvcName = app.Name
+	}
+
+	conf.merge(vConf)
+	return nil
+}
+
+// ApplyEnv overlays the settings found in the environment variables named
+// by the Env constants.
+func (conf *Config) ApplyEnv() {
+	conf.merge(Config{
+		PzAddr:  os.Getenv(EnvPzAddr),
+		AuthKey: os.Getenv(EnvAuthKey),
+		APIKey:  os.Getenv(EnvAPIKey),
+		SvcURL:  os.Getenv(EnvSvcURL),
+		SvcName: os.Getenv(EnvSvcName),
+		Port:    os.Getenv(EnvPort)})
+}
+
+// Validate checks that the Config has a usable gateway address and
+// credentials, and that the service URL and port, if given, are sensible.
+// All problems are reported together.
+func (conf *Config) Validate() error {
+	var problems []string
+
+	if conf.PzAddr == "" {
+		problems = append(problems, "no Pz gateway address (set "+EnvPzAddr+" or bind a pz-gateway service)")
+	} else if msg := checkURL(conf.PzAddr); msg != "" {
+		problems = append(problems, "Pz gateway address "+msg)
+	}
+	if conf.AuthKey == "" && conf.APIKey == "" {
+		problems = append(problems, "no Pz credentials (set "+EnvAPIKey+" or "+EnvAuthKey+")")
+	}
+	if conf.SvcURL != "" {
+		if msg := checkURL(conf.SvcURL); msg != "" {
+			problems = append(problems, "service URL "+msg)
+		}
+	}
+	if conf.Port != "" {
+		if port, err := strconv.Atoi(conf.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, `port "`+conf.Port+`" is not a number between 1 and 65535`)
+		}
+	}
+
+	if len(problems) != 0 {
+		return ErrWithTrace("Invalid configuration: " + strings.Join(problems, "; ") + ".")
+	}
+	return nil
+}
+
+// BindAddr returns the address to listen on, as ":port".  The port defaults
+// to 8080.
+func (conf *Config) BindAddr() string {
+	if conf.Port == "" {
+		return ":8080"
+	}
+	return ":" + conf.Port
+}
+
+// findPzService picks out the bound service describing the Pz gateway.
+func findPzService(svcMap map[string][]vcapService) *vcapService {
+	mentionsPz := func(str string) bool {
+		str = strings.ToLower(str)
+		return strings.Contains(str, "pz-gateway") || strings.Contains(str, "piazza")
+	}
+	for _, svcs := range svcMap {
+		for i, svc := range svcs {
+			if mentionsPz(svc.Name) || mentionsPz(svc.Label) {
+				return &svcs[i]
+			}
+			for _, tag := range svc.Tags {
+				if mentionsPz(tag) {
+					return &svcs[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// credString returns the first of the given credentials that is a
+// non-empty string.
+func credString(creds map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if str, ok := creds[key].(string); ok && str != "" {
+			return str
+		}
+	}
+	return ""
+}
+
+// withScheme adds "https://" to bare host names, as found in VCAP data.
+func withScheme(addr string) string {
+	if addr == "" || strings.Contains(addr, "://") {
+		return addr
+	}
+	return "https://" + addr
+}
+
+// checkURL returns a description of what is wrong with the given address,
+// or the empty string if it is a usable http(s) URL.
+func checkURL(addr string) string {
+	parsed, err := url.Parse(addr)
+	if err != nil {
+		return `"` + addr + `" is not a valid URL: ` + err.Error()
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return `"` + addr + `" must start with http:// or https://`
+	}
+	if parsed.Host == "" {
+		return `"` + addr + `" has no host`
+	}
+	return ""
+}
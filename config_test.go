@@ -0,0 +1,100 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "config.json")
+	ioutil.WriteFile(fName, []byte(`{"pzAddr":"https://file.example.com", "svcName":"fromFile", "port":"9000"}`), 0600)
+
+	t.Setenv("VCAP_SERVICES", `{"user-provided":[
+		{"name":"db", "credentials":{"host":"db.example.com"}},
+		{"name":"my-pz-gateway", "credentials":{"host":"pz.example.com", "api_key":"vcapKey"}}]}`)
+	t.Setenv("VCAP_APPLICATION", `{"name":"fromVCAP", "application_uris":["svc.example.com"]}`)
+	t.Setenv(EnvPzAddr, "")
+	t.Setenv(EnvAuthKey, "")
+	t.Setenv(EnvAPIKey, "")
+	t.Setenv(EnvSvcURL, "")
+	t.Setenv(EnvSvcName, "")
+	t.Setenv(EnvPort, "8181")
+
+	conf, err := LoadConfig(fName)
+	if err != nil {
+		t.Fatal(`TestLoadConfig: load failed: ` + err.Error())
+	}
+	if conf.PzAddr != "https://pz.example.com" || conf.SvcURL != "https://svc.example.com" ||
+		conf.SvcName != "fromVCAP" || conf.Port != "8181" || conf.BindAddr() != ":8181" {
+		t.Errorf(`TestLoadConfig: bad config: %#v`, conf)
+	}
+	if conf.AuthKey != BasicAuthHeader("vcapKey", "") {
+		t.Errorf(`TestLoadConfig: bad auth key "%s"`, conf.AuthKey)
+	}
+
+	t.Setenv(EnvPzAddr, "http://env.example.com")
+	t.Setenv(EnvAuthKey, "Basic literal")
+	if conf, err = LoadConfig(fName); err != nil {
+		t.Fatal(`TestLoadConfig: load failed: ` + err.Error())
+	}
+	if conf.PzAddr != "http://env.example.com" || conf.AuthKey != "Basic literal" {
+		t.Errorf(`TestLoadConfig: environment did not take precedence: %#v`, conf)
+	}
+
+	// a literal key in the file must not outrank an API key from a higher layer
+	ioutil.WriteFile(fName, []byte(`{"pzAddr":"https://file.example.com", "authKey":"Basic fromFile"}`), 0600)
+	t.Setenv("VCAP_SERVICES", "")
+	t.Setenv(EnvAuthKey, "")
+	t.Setenv(EnvAPIKey, "envKey")
+	if conf, err = LoadConfig(fName); err != nil {
+		t.Fatal(`TestLoadConfig: load failed: ` + err.Error())
+	}
+	if conf.AuthKey != BasicAuthHeader("envKey", "") {
+		t.Errorf(`TestLoadConfig: file auth key outranked environment API key: "%s"`, conf.AuthKey)
+	}
+
+	t.Setenv("VCAP_SERVICES", `not json`)
+	if _, err = LoadConfig(""); err == nil {
+		t.Error(`TestLoadConfig: passed on bad VCAP_SERVICES.`)
+	}
+	if _, err = LoadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error(`TestLoadConfig: passed on missing file.`)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	conf := Config{PzAddr: "pz.example.com", SvcURL: "ftp://svc", Port: "70000"}
+	err := conf.Validate()
+	if err == nil {
+		t.Fatal(`TestConfigValidate: passed on bad config.`)
+	}
+	for _, expected := range []string{"gateway address", "credentials", "service URL", "port"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf(`TestConfigValidate: error does not mention %s: %s`, expected, err.Error())
+		}
+	}
+
+	conf = Config{PzAddr: "https://pz.example.com", APIKey: "key"}
+	if err = conf.Validate(); err != nil {
+		t.Error(`TestConfigValidate: failed on good config: ` + err.Error())
+	}
+	if conf.BindAddr() != ":8080" {
+		t.Error(`TestConfigValidate: bad default bind address: ` + conf.BindAddr())
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy describes which cross-origin requests a service accepts.  It is
+// applied through CORS.  Preflight remains as the permissive default for
+// handlers not behind a CORSPolicy.
+type CORSPolicy struct {
+	// AllowedOrigins lists the origins allowed, either exactly (as in
+	// "https://example.com") or as patterns with "*" wildcards (as in
+	// "https://*.example.com").  A lone "*" allows any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc, if set, is consulted for origins not matched by
+	// AllowedOrigins.
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods are the methods allowed on routes without an entry in
+	// RouteMethods.  Defaults to GET and POST.
+	AllowedMethods []string
+	// RouteMethods overrides AllowedMethods by path.  Keys ending in "/"
+	// match all paths beneath them, as with http.ServeMux; the longest
+	// matching key wins.
+	RouteMethods map[string][]string
+	// AllowedHeaders are the request headers allowed.  Defaults to those
+	// allowed by Preflight.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers made visible to scripts.
+	ExposedHeaders []string
+	// AllowCredentials permits cookies and Authorization headers on
+	// cross-origin requests.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results.  Zero leaves
+	// it to the browser.
+	MaxAge time.Duration
+}
+
+var defaultCORSHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"}
+
+type corsKey struct{}
+
+// CORS returns middleware that applies the given policy.  Preflight requests
+// are answered directly - with a 204 if allowed and a 403 if not - and never
+// reach later handlers.  Other cross-origin requests from disallowed origins
+// are passed along without CORS headers, so browsers will refuse them.
+func CORS(policy CORSPolicy) Middleware {
+	if len(policy.AllowedMethods) == 0 {
+		policy.AllowedMethods = []string{"GET", "POST"}
+	}
+	if len(policy.AllowedHeaders) == 0 {
+		policy.AllowedHeaders = defaultCORSHeaders
+	}
+	allowedHeaders := make(map[string]bool)
+	for _, header := range policy.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), corsKey{}, true))
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			isPreflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+			header := w.Header()
+			header.Add("Vary", "Origin")
+
+			if !policy.originAllowed(origin) {
+				if isPreflight {
+					WriteError(w, &HTTPError{Status: http.StatusForbidden, Message: `Origin "` + origin + `" is not allowed.`})
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if policy.AllowCredentials || !policy.allowsAnyOrigin() {
+				header.Set("Access-Control-Allow-Origin", origin)
+			} else {
+				header.Set("Access-Control-Allow-Origin", "*")
+			}
+			if policy.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !isPreflight {
+				if len(policy.ExposedHeaders) != 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			methods := policy.methodsFor(r.URL.Path)
+			reqMethod := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+			if !containsString(methods, reqMethod) {
+				WriteError(w, &HTTPError{Status: http.StatusForbidden, Message: `Method ` + reqMethod + ` is not allowed on ` + r.URL.Path + `.`})
+				return
+			}
+			for _, reqHeader := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				reqHeader = strings.TrimSpace(reqHeader)
+				if reqHeader != "" && !allowedHeaders[http.CanonicalHeaderKey(reqHeader)] {
+					WriteError(w, &HTTPError{Status: http.StatusForbidden, Message: `Header ` + reqHeader + ` is not allowed.`})
+					return
+				}
+			}
+
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if policy.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// corsApplied reports whether the request has passed through CORS
+// middleware, in which case Handler leaves Preflight out of it.
+func corsApplied(r *http.Request) bool {
+	applied, _ := r.Context().Value(corsKey{}).(bool)
+	return applied
+}
+
+func (policy *CORSPolicy) allowsAnyOrigin() bool {
+	return containsString(policy.AllowedOrigins, "*")
+}
+
+func (policy *CORSPolicy) originAllowed(origin string) bool {
+	for _, allowed := range policy.AllowedOrigins {
+		if allowed == origin || allowed == "*" {
+			return true
+		}
+		if strings.Contains(allowed, "*") {
+			if match, err := path.Match(allowed, origin); err == nil && match {
+				return true
+			}
+		}
+	}
+	return policy.AllowOriginFunc != nil && policy.AllowOriginFunc(origin)
+}
+
+// methodsFor returns the methods allowed on the given path.
+func (policy *CORSPolicy) methodsFor(reqPath string) []string {
+	var (
+		methods = policy.AllowedMethods
+		bestLen = -1
+	)
+	for route, routeMethods := range policy.RouteMethods {
+		matches := route == reqPath || (strings.HasSuffix(route, "/") && strings.HasPrefix(reqPath, route))
+		if matches && len(route) > bestLen {
+			methods, bestLen = routeMethods, len(route)
+		}
+	}
+	return methods
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	rt := NewRouter(CORS(CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		RouteMethods:     map[string][]string{"/admin/": {"DELETE"}},
+		ExposedHeaders:   []string{RequestIDHeader},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute}))
+	handled := 0
+	handler := func(w http.ResponseWriter, r *http.Request) error {
+		handled++
+		return nil
+	}
+	rt.HandleFunc("/job", handler)
+	rt.HandleFunc("/admin/", handler)
+
+	preflight := func(origin, method, headers string) map[string]string {
+		return map[string]string{"Origin": origin, "Access-Control-Request-Method": method, "Access-Control-Request-Headers": headers}
+	}
+
+	rec := serveTest(rt, "OPTIONS", "/job", "", preflight("https://app.example.com", "POST", "content-type"))
+	hdr := rec.Header()
+	if rec.Code != http.StatusNoContent ||
+		hdr.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		hdr.Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		hdr.Get("Access-Control-Allow-Credentials") != "true" ||
+		hdr.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf(`TestCORS: bad preflight response: %d %v`, rec.Code, hdr)
+	}
+
+	rec = serveTest(rt, "OPTIONS", "/admin/users", "", preflight("https://x.example.org", "DELETE", ""))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != "DELETE" {
+		t.Errorf(`TestCORS: bad route preflight response: %d %v`, rec.Code, rec.Header())
+	}
+
+	failures := []map[string]string{
+		preflight("https://evil.example.com", "POST", ""),
+		preflight("https://app.example.com", "POST", ""),
+		preflight("https://app.example.com", "POST", "X-Secret"),
+	}
+	for i, headers := range failures {
+		if rec = serveTest(rt, "OPTIONS", "/admin/users", "", headers); rec.Code != http.StatusForbidden {
+			t.Errorf(`TestCORS: disallowed preflight %d gave %d.`, i, rec.Code)
+		}
+	}
+	if handled != 0 {
+		t.Errorf(`TestCORS: preflights reached the handler %d times.`, handled)
+	}
+
+	rec = serveTest(rt, "POST", "/job", "", map[string]string{"Origin": "https://app.example.com"})
+	if handled != 1 || rec.Header().Get("Access-Control-Expose-Headers") != RequestIDHeader ||
+		rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf(`TestCORS: bad actual response: %v`, rec.Header())
+	}
+	rec = serveTest(rt, "POST", "/job", "", map[string]string{"Origin": "https://evil.example.com"})
+	if handled != 2 || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf(`TestCORS: disallowed origin given CORS headers: %v`, rec.Header())
+	}
+
+	rt = NewRouter(CORS(CORSPolicy{AllowedOrigins: []string{"*"}}))
+	rt.HandleFunc("/job", handler)
+	rec = serveTest(rt, "GET", "/job", "", map[string]string{"Origin": "https://anywhere.com"})
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf(`TestCORS: wildcard origin not returned: %v`, rec.Header())
+	}
+}
@@ -15,29 +15,32 @@
 package pzsvc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 )
 
-// locString simplifies certain local processes that wish to interact with
-// files that may or may not be in a subfolder.
-func locString(subFold, fname string) string {
-	if subFold == "" {
-		return fmt.Sprintf(`./%s`, fname)
-	}
-	return fmt.Sprintf(`./%s/%s`, subFold, fname)
-}
-
 // DownloadBytes retrieves a file from Pz using the file access API and then
 // returns the results as a byte slice
 func DownloadBytes(dataID, pzAddr, authKey string) ([]byte, error) {
+	return DownloadBytesContext(context.Background(), dataID, pzAddr, authKey)
+}
 
-	resp, err := SubmitSinglePart("GET", "", pzAddr+"/file/"+dataID, authKey)
+// DownloadBytesContext is DownloadBytes within the trace of the given
+// context.  Ending the context aborts the download.
+func DownloadBytesContext(ctx context.Context, dataID, pzAddr, authKey string) ([]byte, error) {
+
+	resp, err := SubmitSinglePartContext(ctx, "GET", "", pzAddr+"/file/"+dataID, authKey)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -53,22 +56,46 @@ func DownloadBytes(dataID, pzAddr, authKey string) ([]byte, error) {
 	return b, nil
 }
 
-// DownloadByID retrieves a file from Pz using the file access API
+// DownloadByID retrieves a file from Pz using the file access API.  subFold
+// may be empty, relative to the current working directory, or absolute.
 func DownloadByID(dataID, filename, subFold, pzAddr, authKey string) (string, error) {
-	fName, err := DownloadByURL(pzAddr+"/file/"+dataID, filename, subFold, authKey)
+	return DownloadByIDFS(DirFS(subFold), dataID, filename, pzAddr, authKey)
+}
+
+// DownloadByIDFS retrieves a file from Pz using the file access API and
+// writes it to the given FileSystem.
+func DownloadByIDFS(fsys FileSystem, dataID, filename, pzAddr, authKey string) (string, error) {
+	return DownloadByIDFSContext(context.Background(), fsys, dataID, filename, pzAddr, authKey)
+}
+
+// DownloadByIDFSContext is DownloadByIDFS within the trace of the given
+// context.  Ending the context aborts the download.
+func DownloadByIDFSContext(ctx context.Context, fsys FileSystem, dataID, filename, pzAddr, authKey string) (string, error) {
+	fName, err := DownloadByURLFSContext(ctx, fsys, pzAddr+"/file/"+dataID, filename, authKey)
 	if err == nil && fName == "" {
 		return "", ErrWithTrace(`File for DataID ` + dataID + ` unnamed.  Probable ingest error.`)
 	}
 	return fName, err
 }
 
-// DownloadByURL retrieves a file from the given URL
+// DownloadByURL retrieves a file from the given URL.  subFold may be empty,
+// relative to the current working directory, or absolute.
 func DownloadByURL(url, filename, subFold, authKey string) (string, error) {
+	return DownloadByURLFS(DirFS(subFold), url, filename, authKey)
+}
+
+// DownloadByURLFS retrieves a file from the given URL and writes it to the
+// given FileSystem.  If filename is empty, the name is taken from the
+// Content-Disposition header of the response, less any directories.
+func DownloadByURLFS(fsys FileSystem, url, filename, authKey string) (string, error) {
+	return DownloadByURLFSContext(context.Background(), fsys, url, filename, authKey)
+}
 
-	var (
-		params map[string]string
-	)
-	resp, err := SubmitSinglePart("GET", "", url, authKey)
+// DownloadByURLFSContext is DownloadByURLFS within the trace of the given
+// context.  Ending the context aborts the download.
+func DownloadByURLFSContext(ctx context.Context, fsys FileSystem, url, filename, authKey string) (string, error) {
+
+	resp, err := SubmitSinglePartContext(ctx, "GET", "", url, authKey)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -76,40 +103,203 @@ func DownloadByURL(url, filename, subFold, authKey string) (string, error) {
 		return "", TraceErr(err)
 	}
 	if filename == "" {
-		contDisp := resp.Header.Get("Content-Disposition")
-		_, params, err = mime.ParseMediaType(contDisp)
+		filename, err = contDispName(resp)
 		if err != nil {
 			return "", TraceErr(err)
 		}
-		filename = params["filename"]
 		if filename == "" {
 			return "", ErrWithTrace(`Input file from URL "` + url + `" was not given a name.`)
 		}
 	}
-	out, err := os.Create(locString(subFold, filename))
+	out, err := fsys.Create(filename)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
 	if err != nil {
 		return "", TraceErr(err)
 	}
 
-	defer out.Close()
-	io.Copy(out, resp.Body)
+	return filename, nil
+}
+
+// DownloadToWriter retrieves a file from the given URL and copies it to the
+// given writer.  It returns the filename given in the Content-Disposition
+// header of the response, if any.
+func DownloadToWriter(url, authKey string, w io.Writer) (string, error) {
+	return DownloadToWriterContext(context.Background(), url, authKey, w)
+}
+
+// DownloadToWriterContext is DownloadToWriter within the trace of the given
+// context.  Ending the context aborts the download.
+func DownloadToWriterContext(ctx context.Context, url, authKey string, w io.Writer) (string, error) {
 
+	resp, err := SubmitSinglePartContext(ctx, "GET", "", url, authKey)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return "", TraceErr(err)
+	}
+	filename, _ := contDispName(resp)
 	return filename, nil
 }
 
-// Ingest ingests the given bytes to Piazza.
+// contDispName pulls the filename out of the Content-Disposition header of
+// the given response.  A missing header is not an error.
+func contDispName(resp *http.Response) (string, error) {
+	contDisp := resp.Header.Get("Content-Disposition")
+	if contDisp == "" {
+		return "", nil
+	}
+	_, params, err := mime.ParseMediaType(contDisp)
+	if err != nil || params["filename"] == "" {
+		return "", err
+	}
+	// The name comes from the server, and must not be allowed to pick the
+	// directory it is written to.
+	name := path.Base(strings.Replace(params["filename"], `\`, "/", -1))
+	if name == "/" || name == "." || name == ".." || !filepath.IsLocal(name) {
+		return "", ErrWithTrace(`Unusable filename "` + params["filename"] + `" in Content-Disposition.`)
+	}
+	return name, nil
+}
+
+// IngestValidator is a check run by Ingest on the data to be ingested, before
+// anything is sent to Pz.  It may also fill in parts of the DataDesc that will
+// accompany the data, such as its MimeType or SpatMeta.  Returning an error
+// aborts the ingest.
+type IngestValidator func(ingData []byte, dRes *DataDesc) error
+
+var (
+	ingestValidators = map[string]IngestValidator{
+		"geojson":   ValidateGeoJSONIngest,
+		"raster":    ValidateRasterIngest,
+		"shapefile": ValidateShapefileIngest,
+	}
+	ingestValidLock sync.RWMutex
+)
+
+// SetIngestValidator sets the validator that Ingest runs for the given file
+// type, replacing any existing one.  A nil validator disables validation for
+// that type.
+func SetIngestValidator(fType string, validator IngestValidator) {
+	ingestValidLock.Lock()
+	defer ingestValidLock.Unlock()
+	if validator == nil {
+		delete(ingestValidators, fType)
+		return
+	}
+	ingestValidators[fType] = validator
+}
+
+func getIngestValidator(fType string) IngestValidator {
+	ingestValidLock.RLock()
+	defer ingestValidLock.RUnlock()
+	return ingestValidators[fType]
+}
+
+// Ingest ingests the given bytes to Piazza, marked with the default
+// classification.  If there is an IngestValidator for the given file type,
+// the bytes must pass it first.
 func Ingest(fName, fType, pzAddr, sourceName, version, authKey string,
 	ingData []byte,
 	props map[string]string) (string, error) {
 
+	return IngestContext(context.Background(), fName, fType, pzAddr, sourceName, version, authKey, ingData, props)
+}
+
+// IngestContext is Ingest within the trace of the given context.  Ending
+// the context stops the wait for the ingest job, but not the job itself.
+func IngestContext(ctx context.Context, fName, fType, pzAddr, sourceName, version, authKey string,
+	ingData []byte,
+	props map[string]string) (string, error) {
+
+	return IngestWithClassContext(ctx, fName, fType, pzAddr, sourceName, version, authKey, DefaultClassType(), ingData, props)
+}
+
+// IngestWithClass is Ingest, with the resource marked with the given
+// classification rather than the default.  The classification must pass
+// ValidateClassType.
+func IngestWithClass(fName, fType, pzAddr, sourceName, version, authKey string,
+	class ClassType,
+	ingData []byte,
+	props map[string]string) (string, error) {
+
+	return IngestWithClassContext(context.Background(), fName, fType, pzAddr, sourceName, version, authKey, class, ingData, props)
+}
+
+// IngestWithClassContext is IngestWithClass within the trace of the given
+// context.  Ending the context stops the wait for the ingest job, but not
+// the job itself.
+func IngestWithClassContext(ctx context.Context, fName, fType, pzAddr, sourceName, version, authKey string,
+	class ClassType,
+	ingData []byte,
+	props map[string]string) (string, error) {
+
+	jobID, err := submitIngest(ctx, fName, fType, pzAddr, sourceName, version, authKey, class, ingData, props)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+
+	result, err := GetJobResponseContext(ctx, jobID, pzAddr, authKey)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+
+	return result.DataID, nil
+}
+
+// IngestAsync is IngestWithClass, except that it returns as soon as Pz has
+// accepted the ingest job, with a Job handle through which to await its
+// completion.  The result of a successful ingest job is an IngestResult.
+func IngestAsync(fName, fType, pzAddr, sourceName, version, authKey string,
+	class ClassType,
+	ingData []byte,
+	props map[string]string) (*Job, error) {
+
+	return IngestAsyncContext(context.Background(), fName, fType, pzAddr, sourceName, version, authKey, class, ingData, props)
+}
+
+// IngestAsyncContext is IngestAsync, with the submission and the job traced
+// within the trace of the given context.
+func IngestAsyncContext(ctx context.Context, fName, fType, pzAddr, sourceName, version, authKey string,
+	class ClassType,
+	ingData []byte,
+	props map[string]string) (*Job, error) {
+
+	jobID, err := submitIngest(ctx, fName, fType, pzAddr, sourceName, version, authKey, class, ingData, props)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	return NewJobContext(ctx, jobID, pzAddr, authKey), nil
+}
+
+// submitIngest builds and submits an ingest job, and returns its job ID.
+func submitIngest(ctx context.Context, fName, fType, pzAddr, sourceName, version, authKey string,
+	class ClassType,
+	ingData []byte,
+	props map[string]string) (string, error) {
+
 	var fileData []byte
 	var resp *http.Response
 
+	if err := ValidateClassType(class); err != nil {
+		return "", TraceErr(err)
+	}
+
 	desc := fmt.Sprintf("%s uploaded by %s.", fType, sourceName)
 	rMeta := ResMeta{
 		Name:        fName,
 		Format:      fType,
-		ClassType:   ClassType{"UNCLASSIFIED"},
+		ClassType:   class,
 		Version:     version,
 		Description: desc,
 		Metadata:    make(map[string]string)}
@@ -123,7 +313,7 @@ func Ingest(fName, fType, pzAddr, sourceName, version, authKey string,
 	switch fType {
 	case "raster":
 		{
-			//dType.MimeType = "image/tiff"
+			dType.MimeType = "image/tiff"
 			fileData = ingData
 		}
 	case "geojson":
@@ -131,6 +321,11 @@ func Ingest(fName, fType, pzAddr, sourceName, version, authKey string,
 			dType.MimeType = "application/vnd.geo+json"
 			fileData = ingData
 		}
+	case "shapefile":
+		{
+			dType.MimeType = "application/zip"
+			fileData = ingData
+		}
 	case "text":
 		{
 			dType.MimeType = "application/text"
@@ -140,6 +335,11 @@ func Ingest(fName, fType, pzAddr, sourceName, version, authKey string,
 	}
 
 	dRes := DataDesc{"", dType, rMeta, nil}
+	if validator := getIngestValidator(fType); validator != nil {
+		if err := validator(ingData, &dRes); err != nil {
+			return "", TraceErr(err)
+		}
+	}
 	jType := IngestReq{dRes, true, "ingest"}
 	bbuff, err := json.Marshal(jType)
 	if err != nil {
@@ -147,41 +347,64 @@ func Ingest(fName, fType, pzAddr, sourceName, version, authKey string,
 	}
 
 	if fileData != nil {
-		resp, err = SubmitMultipart(string(bbuff), (pzAddr + "/data/file"), fName, authKey, fileData)
+		resp, err = SubmitMultipartContext(ctx, string(bbuff), (pzAddr + "/data/file"), fName, authKey, fileData)
 	} else {
-		resp, err = SubmitSinglePart("POST", string(bbuff), (pzAddr + "/data"), authKey)
+		resp, err = SubmitSinglePartContext(ctx, "POST", string(bbuff), (pzAddr + "/data"), authKey)
 	}
 	if err != nil {
 		return "", TraceErr(err)
 	}
 
 	jobID, err := GetJobID(resp)
-	if err != nil {
-		return "", TraceErr(err)
-	}
+	return jobID, TraceErr(err)
+}
 
-	result, err := GetJobResponse(jobID, pzAddr, authKey)
+// IngestFile ingests the given file to Piazza.  subFold may be empty,
+// relative to the current working directory, or absolute.
+func IngestFile(fName, subFold, fType, pzAddr, sourceName, version, authKey string,
+	props map[string]string) (string, error) {
+
+	return IngestFileFS(DirFS(subFold), fName, fType, pzAddr, sourceName, version, authKey, props)
+}
+
+// IngestFileFS ingests the named file from the given FileSystem to Piazza.
+// The base name of the file is used as the name of the Piazza resource.
+func IngestFileFS(fsys FileSystem, fName, fType, pzAddr, sourceName, version, authKey string,
+	props map[string]string) (string, error) {
+
+	file, err := fsys.Open(fName)
 	if err != nil {
 		return "", TraceErr(err)
 	}
+	defer file.Close()
 
-	return result.DataID, TraceErr(err)
+	return IngestReader(filepath.Base(fName), fType, pzAddr, sourceName, version, authKey, file, props)
 }
 
-// IngestFile ingests the given file to Piazza
-func IngestFile(fName, subFold, fType, pzAddr, sourceName, version, authKey string,
+// IngestReader reads the given reader to completion and ingests the
+// results to Piazza under the given name.
+func IngestReader(fName, fType, pzAddr, sourceName, version, authKey string,
+	reader io.Reader,
 	props map[string]string) (string, error) {
 
-	path := locString(subFold, fName)
-
-	fData, err := ioutil.ReadFile(path)
+	fData, err := readIngestData(fName, reader)
 	if err != nil {
 		return "", TraceErr(err)
 	}
+	return Ingest(fName, fType, pzAddr, sourceName, version, authKey, fData, props)
+}
+
+// readIngestData reads the given reader to completion, and rejects the
+// results if empty.
+func readIngestData(fName string, reader io.Reader) ([]byte, error) {
+	fData, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
 	if len(fData) == 0 {
-		return "", ErrWithTrace(`File "` + fName + `" read as empty.`)
+		return nil, ErrWithTrace(`File "` + fName + `" read as empty.`)
 	}
-	return Ingest(fName, fType, pzAddr, sourceName, version, authKey, fData, props)
+	return fData, nil
 }
 
 // GetFileMeta retrieves the metadata for a given dataID in the S3 bucket
@@ -213,6 +436,176 @@ func UpdateFileMeta(dataID, pzAddr, authKey string, newMeta map[string]string) e
 	return TraceErr(err)
 }
 
+// MetaPatch is a set of changes to make to the metadata of a resource through
+// PatchFileMeta.  Nil pointer fields are left as they are.  Key/value list
+// entries are matched by key, and replaced if already present.  Deletions are
+// applied before additions.
+type MetaPatch struct {
+	Name         *string
+	Description  *string
+	Tags         *string
+	ClassType    *ClassType
+	SetMeta      map[string]string // added to or overwritten in Metadata
+	DeleteMeta   []string          // removed from Metadata
+	SetNumKeyVal []NumKeyVal
+	SetTxtKeyVal []TxtKeyVal
+	DeleteKeyVal []string // removed from both key/value lists
+}
+
+// metaPatchReq is the body PatchFileMeta sends to Pz.  It holds only the
+// fields the patch touches, and sends those even when they have been
+// emptied, so that clearing a field or removing the last entry of a map or
+// list reaches Pz.  Untouched fields are nil, and left out.
+type metaPatchReq struct {
+	Name          *string            `json:"name,omitempty"`
+	Description   *string            `json:"description,omitempty"`
+	Tags          *string            `json:"tags,omitempty"`
+	ClassType     *ClassType         `json:"classType,omitempty"`
+	Metadata      *map[string]string `json:"metadata,omitempty"`
+	NumKeyValList *[]NumKeyVal       `json:"numericKeyValueList,omitempty"`
+	TxtKeyValList *[]TxtKeyVal       `json:"textKeyValueList,omitempty"`
+}
+
+// request builds the body for sending the patch, as applied to meta.
+func (patch MetaPatch) request(meta ResMeta) metaPatchReq {
+	req := metaPatchReq{
+		Name:        patch.Name,
+		Description: patch.Description,
+		Tags:        patch.Tags,
+		ClassType:   patch.ClassType}
+	if len(patch.SetMeta) > 0 || len(patch.DeleteMeta) > 0 {
+		if meta.Metadata == nil {
+			meta.Metadata = make(map[string]string)
+		}
+		req.Metadata = &meta.Metadata
+	}
+	if len(patch.SetNumKeyVal) > 0 || len(patch.DeleteKeyVal) > 0 {
+		if meta.NumKeyValList == nil {
+			meta.NumKeyValList = []NumKeyVal{}
+		}
+		req.NumKeyValList = &meta.NumKeyValList
+	}
+	if len(patch.SetTxtKeyVal) > 0 || len(patch.DeleteKeyVal) > 0 {
+		if meta.TxtKeyValList == nil {
+			meta.TxtKeyValList = []TxtKeyVal{}
+		}
+		req.TxtKeyValList = &meta.TxtKeyValList
+	}
+	return req
+}
+
+// MetaConflictError is returned by PatchFileMeta when the metadata of the
+// resource was changed by someone else while the patch was being applied.
+type MetaConflictError struct {
+	DataID string
+}
+
+func (err MetaConflictError) Error() string {
+	return `Metadata for DataID ` + err.DataID + ` changed during update.  Patch not applied.`
+}
+
+// Apply makes the changes in the patch to the given metadata.
+func (patch MetaPatch) Apply(meta *ResMeta) {
+	if patch.Name != nil {
+		meta.Name = *patch.Name
+	}
+	if patch.Description != nil {
+		meta.Description = *patch.Description
+	}
+	if patch.Tags != nil {
+		meta.Tags = *patch.Tags
+	}
+	if patch.ClassType != nil {
+		meta.ClassType = *patch.ClassType
+	}
+
+	newMeta := make(map[string]string)
+	for key, val := range meta.Metadata {
+		newMeta[key] = val
+	}
+	for _, key := range patch.DeleteMeta {
+		delete(newMeta, key)
+	}
+	for key, val := range patch.SetMeta {
+		newMeta[key] = val
+	}
+	meta.Metadata = newMeta
+
+	numDrop, txtDrop := make(map[string]bool), make(map[string]bool)
+	for _, key := range patch.DeleteKeyVal {
+		numDrop[key], txtDrop[key] = true, true
+	}
+	for _, kv := range patch.SetNumKeyVal {
+		numDrop[kv.Key] = true
+	}
+	for _, kv := range patch.SetTxtKeyVal {
+		txtDrop[kv.Key] = true
+	}
+
+	var numList []NumKeyVal
+	for _, kv := range meta.NumKeyValList {
+		if !numDrop[kv.Key] {
+			numList = append(numList, kv)
+		}
+	}
+	meta.NumKeyValList = append(numList, patch.SetNumKeyVal...)
+
+	var txtList []TxtKeyVal
+	for _, kv := range meta.TxtKeyValList {
+		if !txtDrop[kv.Key] {
+			txtList = append(txtList, kv)
+		}
+	}
+	meta.TxtKeyValList = append(txtList, patch.SetTxtKeyVal...)
+}
+
+// PatchFileMeta reads the current metadata for the given dataID, applies the
+// patch to it, and writes the fields it touches back, returning the updated
+// DataDesc.  Unlike UpdateFileMeta, anything not mentioned in the patch is
+// preserved.
+// If the metadata changes between the read and the write, the patch is not
+// applied and a MetaConflictError is returned, so that the caller can retry.
+// A ClassType in the patch must pass ValidateClassType.
+// Pz offers no way to make this atomic, so the check narrows the window
+// for a lost update rather than closing it.
+func PatchFileMeta(dataID, pzAddr, authKey string, patch MetaPatch) (*DataDesc, error) {
+
+	if patch.ClassType != nil {
+		if err := ValidateClassType(*patch.ClassType); err != nil {
+			return nil, TraceErr(err)
+		}
+	}
+
+	orig, err := GetFileMeta(dataID, pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+
+	updated := *orig
+	patch.Apply(&updated.ResMeta)
+	jbuff, err := json.Marshal(patch.request(updated.ResMeta))
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+
+	current, err := GetFileMeta(dataID, pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	if !reflect.DeepEqual(orig.ResMeta, current.ResMeta) {
+		return nil, MetaConflictError{DataID: dataID}
+	}
+
+	resp, err := SubmitSinglePart("POST", string(jbuff), fmt.Sprintf(`%s/data/%s`, pzAddr, dataID), authKey)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	return &updated, nil
+}
+
 // SearchFileMeta takes a search string, Pz address, and Pz Auth, and returns
 // a list of all file metadata such that the search string appears somewhere
 // in the metadata.  It was useful once and may be useful again, but it is not
@@ -234,24 +627,82 @@ func SearchFileMeta(searchString, pzAddr, authKey string) ([]DataDesc, error) {
 // the new layer.  If lGroupID is included, the layer is also added to the layer
 // group with that ID.
 func DeployToGeoServer(dataID, lGroupID, pzAddr, authKey string) (*DeplStrct, error) {
-	outJSON := `{"dataId":"` + dataID + `","deploymentGroupId":"` + lGroupID + `","deploymentType":"geoserver","type":"access"}`
+	return DeployToGeoServerContext(context.Background(), dataID, lGroupID, pzAddr, authKey)
+}
 
-	resp, err := SubmitSinglePart("POST", outJSON, pzAddr+"/deployment", authKey)
+// DeployToGeoServerContext is DeployToGeoServer within the trace of the
+// given context.  Ending the context stops the wait for the deployment job,
+// but not the job itself.
+func DeployToGeoServerContext(ctx context.Context, dataID, lGroupID, pzAddr, authKey string) (*DeplStrct, error) {
+	result, err := DeployContext(ctx, DeplReq{DataID: dataID, DeplGroupID: lGroupID, DeplType: "geoserver"}, pzAddr, authKey)
 	if err != nil {
 		return nil, TraceErr(err)
 	}
+	return &result.Deployment, nil
+}
 
-	jobID, err := GetJobID(resp)
+// Deploy submits the given deployment request to Pz and waits for the resulting
+// job to complete.  Type defaults to "access" and DeplType to "geoserver" if left
+// empty.
+func Deploy(req DeplReq, pzAddr, authKey string) (*DataResult, error) {
+	return DeployContext(context.Background(), req, pzAddr, authKey)
+}
+
+// DeployContext is Deploy within the trace of the given context.  Ending the
+// context stops the wait for the deployment job, but not the job itself.
+func DeployContext(ctx context.Context, req DeplReq, pzAddr, authKey string) (*DataResult, error) {
+	jobID, err := submitDeploy(ctx, req, pzAddr, authKey)
 	if err != nil {
 		return nil, TraceErr(err)
 	}
 
-	result, err := GetJobResponse(jobID, pzAddr, authKey)
+	result, err := GetJobResponseContext(ctx, jobID, pzAddr, authKey)
 	if err != nil {
 		return nil, TraceErr(err)
 	}
 
-	return &result.Deployment, nil
+	return result, nil
+}
+
+// DeployAsync is Deploy, except that it returns as soon as Pz has accepted
+// the deployment job, with a Job handle through which to await its
+// completion.  The result of a successful deployment job is a
+// DeploymentResult.
+func DeployAsync(req DeplReq, pzAddr, authKey string) (*Job, error) {
+	return DeployAsyncContext(context.Background(), req, pzAddr, authKey)
+}
+
+// DeployAsyncContext is DeployAsync, with the submission and the job traced
+// within the trace of the given context.
+func DeployAsyncContext(ctx context.Context, req DeplReq, pzAddr, authKey string) (*Job, error) {
+	jobID, err := submitDeploy(ctx, req, pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	return NewJobContext(ctx, jobID, pzAddr, authKey), nil
+}
+
+// submitDeploy fills in the defaults of a deployment request, submits it,
+// and returns the ID of the resulting job.
+func submitDeploy(ctx context.Context, req DeplReq, pzAddr, authKey string) (string, error) {
+	if req.Type == "" {
+		req.Type = "access"
+	}
+	if req.DeplType == "" {
+		req.DeplType = "geoserver"
+	}
+	outJSON, err := json.Marshal(req)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+
+	resp, err := SubmitSinglePartContext(ctx, "POST", string(outJSON), pzAddr+"/deployment", authKey)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+
+	jobID, err := GetJobID(resp)
+	return jobID, TraceErr(err)
 }
 
 // AddGeoServerLayerGroup takes the bare-bones contact information for the local Piazza
@@ -259,18 +710,90 @@ func DeployToGeoServer(dataID, lGroupID, pzAddr, authKey string) (*DeplStrct, er
 // uuid for that layer group (or an error).
 func AddGeoServerLayerGroup(pzAddr, authKey string) (string, error) {
 
-	type dataStruct struct {
-		DeploymentGroupID string `json:"deploymentGroupId,omitempty"`
-		CreatedBy         string `json:"createdBy,omitempty"`
-		HasGeoServerLayer bool   `json:"hasGisServerLayer,omitempty"`
+	var respObj DeplGroupResp
+
+	_, err := RequestKnownJSON("POST", "", pzAddr+"/deployment/group", authKey, &respObj)
+
+	return respObj.Data.DeplGroupID, TraceErr(err)
+}
+
+// GetDeployment retrieves the deployment with the given ID.
+func GetDeployment(deplID, pzAddr, authKey string) (*DeplStrct, error) {
+
+	var respObj DeplResp
+
+	if _, err := RequestKnownJSON("GET", "", pzAddr+"/deployment/"+deplID, authKey, &respObj); err != nil {
+		return nil, TraceErr(err)
 	}
+	return &respObj.Data.Deployment, nil
+}
+
+// ListDeployments retrieves the deployments matching the given keyword, under
+// the given pagination.  Any of the three may be left empty.
+func ListDeployments(keyword, perPage, pageNo, pzAddr, authKey string) ([]DeplStrct, error) {
 
-	var respObj struct {
-		Type string     `json:"type,omitempty"`
-		Data dataStruct `json:"data,omitempty"`
+	qParams := "keyword=" + url.QueryEscape(keyword)
+	if perPage != "" {
+		qParams += "&perPage=" + perPage
+	}
+	if pageNo != "" {
+		qParams += "&page=" + pageNo
 	}
 
-	_, err := RequestKnownJSON("POST", "", pzAddr+"/deployment/group", authKey, &respObj)
+	var respObj DeplList
+
+	if _, err := RequestKnownJSON("GET", "", pzAddr+"/deployment?"+qParams, authKey, &respObj); err != nil {
+		return nil, TraceErr(err)
+	}
+	return respObj.Data, nil
+}
 
-	return respObj.Data.DeploymentGroupID, TraceErr(err)
+// DeleteDeployment removes the deployment with the given ID, taking the
+// associated layer down from GeoServer.
+func DeleteDeployment(deplID, pzAddr, authKey string) error {
+	resp, err := SubmitSinglePart("DELETE", "", pzAddr+"/deployment/"+deplID, authKey)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return TraceErr(err)
+}
+
+// GetDeploymentGroup retrieves the deployment group (GeoServer layer group)
+// with the given ID.
+func GetDeploymentGroup(lGroupID, pzAddr, authKey string) (*DeplGroup, error) {
+
+	var respObj DeplGroupResp
+
+	if _, err := RequestKnownJSON("GET", "", pzAddr+"/deployment/group/"+lGroupID, authKey, &respObj); err != nil {
+		return nil, TraceErr(err)
+	}
+	return &respObj.Data, nil
+}
+
+// DeleteDeploymentGroup removes the deployment group (GeoServer layer group)
+// with the given ID.  The deployments in the group are not themselves removed.
+func DeleteDeploymentGroup(lGroupID, pzAddr, authKey string) error {
+	resp, err := SubmitSinglePart("DELETE", "", pzAddr+"/deployment/group/"+lGroupID, authKey)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return TraceErr(err)
+}
+
+// EnsureDeployed returns the existing GeoServer deployment for the given dataID
+// if there is one, and deploys it through DeployToGeoServer if there is not.
+// Worth noting that an existing deployment is returned as-is, and will not be
+// added to the layer group given by lGroupID.
+func EnsureDeployed(dataID, lGroupID, pzAddr, authKey string) (*DeplStrct, error) {
+
+	depls, err := ListDeployments(dataID, "1000", "", pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	for i := range depls {
+		if depls[i].DataID == dataID {
+			return &depls[i], nil
+		}
+	}
+	return DeployToGeoServer(dataID, lGroupID, pzAddr, authKey)
 }
@@ -15,13 +15,17 @@
 package pzsvc
 
 import (
+	"encoding/binary"
 	//"encoding/json"
 	//"fmt"
 	//"io"
 	"io/ioutil"
+	"net/http"
 	//"mime"
 	//"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -63,7 +67,7 @@ func TestDownloadByID(t *testing.T) {
 	if err != nil {
 		t.Error(`TestDownloadByID: failed on subfolder-no call: ` + err.Error())
 	}
-	os.Remove(locString("", fileName))
+	os.Remove(fileName)
 
 	_, err = DownloadByID(dataID, "", "", url, authKey)
 	if err == nil {
@@ -94,10 +98,18 @@ func TestIngestFile(t *testing.T) {
 	if err != nil {
 		t.Error(`TestIngestFile: error on text ingest: ` + err.Error())
 	}
+	err = ioutil.WriteFile("./"+subFold+"/"+fileName, []byte(`{"type":"Point","coordinates":[1,2]}`), 0666)
+	if err != nil {
+		t.Error(`TestIngestFile: error on file rewrite: ` + err.Error())
+	}
 	_, err = IngestFile(fileName, subFold, "geojson", url, "tester", "0.0", authKey, map[string]string{"prop1": "1", "prop2": "2"})
 	if err != nil {
 		t.Error(`TestIngestFile: error on geojson ingest: ` + err.Error())
 	}
+	err = ioutil.WriteFile("./"+subFold+"/"+fileName, buildTestTiff(false, binary.LittleEndian), 0666)
+	if err != nil {
+		t.Error(`TestIngestFile: error on file rewrite: ` + err.Error())
+	}
 	_, err = IngestFile(fileName, subFold, "raster", url, "tester", "0.0", authKey, map[string]string{"prop1": "1", "prop2": "2"})
 	if err != nil {
 		t.Error(`TestIngestFile: error on raster ingest: ` + err.Error())
@@ -155,3 +167,212 @@ func TestAddGeoServerLayerGroup(t *testing.T) {
 		t.Error(`TestDeployToGeoServer: error: ` + err.Error())
 	}
 }
+
+func TestDownloadByURLFS(t *testing.T) {
+	outStrs := []string{`{"test":"blah"}`, `{"test":"blah"}`}
+	SetMockClient(outStrs, 250)
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+	fileName := "tempTestFile.tmp"
+
+	memFS := NewMemFS()
+	_, err := DownloadByURLFS(memFS, url, fileName, authKey)
+	if err != nil {
+		t.Error(`TestDownloadByURLFS: failed on MemFS call: ` + err.Error())
+	}
+	byts, err := memFS.ReadFile(fileName)
+	if err != nil || string(byts) != `{"test":"blah"}` {
+		t.Error(`TestDownloadByURLFS: MemFS contents incorrect: "` + string(byts) + `"`)
+	}
+
+	tempDir, err := ioutil.TempDir("", "pzsvc")
+	if err != nil {
+		t.Fatal(`TestDownloadByURLFS: could not create temp dir: ` + err.Error())
+	}
+	defer os.RemoveAll(tempDir)
+	_, err = DownloadByURL(url, fileName, tempDir, authKey)
+	if err != nil {
+		t.Error(`TestDownloadByURLFS: failed on absolute path call: ` + err.Error())
+	}
+	if _, err = os.Stat(filepath.Join(tempDir, fileName)); err != nil {
+		t.Error(`TestDownloadByURLFS: file not written to absolute path: ` + err.Error())
+	}
+
+	prev := HTTPClient()
+	defer SetHTTPClient(prev)
+	contDisp := ""
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header := make(http.Header)
+		header.Set("Content-Disposition", contDisp)
+		return &http.Response{StatusCode: 200, Header: header, Body: GetMockReadCloser("data")}, nil
+	})})
+	for _, name := range []string{"/etc/cron.d/x", "../../x", `..\..\x`} {
+		contDisp = `attachment; filename="` + name + `"`
+		outName, err := DownloadByURL(url, "", tempDir, authKey)
+		if err != nil || outName != "x" {
+			t.Errorf(`TestDownloadByURLFS: header name "%s" gave "%s", %v`, name, outName, err)
+		}
+	}
+	contDisp = `attachment; filename=".."`
+	if _, err = DownloadByURL(url, "", tempDir, authKey); err == nil {
+		t.Error(`TestDownloadByURLFS: passed on header name "..".`)
+	}
+
+	outside := t.TempDir()
+	os.Symlink(outside, filepath.Join(tempDir, "link"))
+	for _, name := range []string{"../escape", filepath.Join(outside, "escape"), "link/escape"} {
+		if out, err := DirFS(tempDir).Create(name); err == nil {
+			out.Close()
+			t.Errorf(`TestDownloadByURLFS: DirFS created "%s" outside its root.`, name)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Error(`TestDownloadByURLFS: file written outside the target directory.`)
+	}
+}
+
+func TestIngestFileFS(t *testing.T) {
+	outStrs := []string{
+		`{"Data":{"JobID":"testID1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"testData1"}}}`}
+	SetMockClient(outStrs, 250)
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+	fileName := "sub/tempTestFile.tmp"
+
+	memFS := NewMemFS()
+	memFS.WriteFile(fileName, []byte(fileName))
+	dataID, err := IngestFileFS(memFS, fileName, "text", url, "tester", "0.0", authKey, nil)
+	if err != nil {
+		t.Error(`TestIngestFileFS: error on text ingest: ` + err.Error())
+	} else if dataID != "testData1" {
+		t.Error(`TestIngestFileFS: incorrect DataID: "` + dataID + `"`)
+	}
+	_, err = IngestFileFS(memFS, "missing.tmp", "text", url, "tester", "0.0", authKey, nil)
+	if err == nil {
+		t.Error(`TestIngestFileFS: passed on missing file.`)
+	}
+}
+
+func TestDeploymentManagement(t *testing.T) {
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+	dataID := "1234ID"
+
+	outStrs := []string{
+		`{"type":"deployment", "data":{"deployment":{"deploymentId":"depl1", "dataId":"1234ID"}}}`,
+		`{"type":"deployment-list", "data":[{"deploymentId":"depl0", "dataId":"0000ID"}, {"deploymentId":"depl1", "dataId":"1234ID"}]}`,
+		`{"type":"deployment-list", "data":[{"deploymentId":"depl1", "dataId":"1234ID"}]}`,
+		`{"type":"deployment-list", "data":[]}`,
+		`{"Data":{"JobID":"testID"}}`,
+		`{"Data":{"Status":"Success", "Result":{"Deployment":{"deploymentId":"depl2"}}}}`,
+		`{"type":"deployment-group", "data":{"deploymentGroupId":"deplG"}}`}
+	SetMockClient(outStrs, 250)
+
+	depl, err := GetDeployment("depl1", url, authKey)
+	if err != nil || depl.DataID != dataID {
+		t.Errorf(`TestDeploymentManagement: GetDeployment failed: %v, %#v`, err, depl)
+	}
+	depls, err := ListDeployments("", "", "", url, authKey)
+	if err != nil || len(depls) != 2 {
+		t.Errorf(`TestDeploymentManagement: ListDeployments failed: %v, %#v`, err, depls)
+	}
+	depl, err = EnsureDeployed(dataID, "", url, authKey)
+	if err != nil || depl.DeplID != "depl1" {
+		t.Errorf(`TestDeploymentManagement: EnsureDeployed did not reuse deployment: %v, %#v`, err, depl)
+	}
+	depl, err = EnsureDeployed(dataID, "", url, authKey)
+	if err != nil || depl.DeplID != "depl2" {
+		t.Errorf(`TestDeploymentManagement: EnsureDeployed did not deploy: %v, %#v`, err, depl)
+	}
+	group, err := GetDeploymentGroup("deplG", url, authKey)
+	if err != nil || group.DeplGroupID != "deplG" {
+		t.Errorf(`TestDeploymentManagement: GetDeploymentGroup failed: %v, %#v`, err, group)
+	}
+	if err = DeleteDeployment("depl1", url, authKey); err != nil {
+		t.Error(`TestDeploymentManagement: DeleteDeployment failed: ` + err.Error())
+	}
+	if err = DeleteDeploymentGroup("deplG", url, authKey); err != nil {
+		t.Error(`TestDeploymentManagement: DeleteDeploymentGroup failed: ` + err.Error())
+	}
+	SetMockClient(nil, 404)
+	if err = DeleteDeployment("depl1", url, authKey); err == nil {
+		t.Error(`TestDeploymentManagement: DeleteDeployment passed on bad status.`)
+	}
+}
+
+func TestDeploy(t *testing.T) {
+	outStrs := []string{`{"Data":{"JobID":"testID"}}`, `{"Data":{"Status":"Success", "Result":{"DataID":"1234ID"}}}`}
+	SetMockClient(outStrs, 250)
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+
+	req := DeplReq{DataID: `12"34ID`, DeplType: "file", Style: "point"}
+	result, err := Deploy(req, url, authKey)
+	if err != nil {
+		t.Error(`TestDeploy: error: ` + err.Error())
+	} else if result.DataID != "1234ID" {
+		t.Error(`TestDeploy: incorrect result: "` + result.DataID + `"`)
+	}
+}
+
+func TestPatchFileMeta(t *testing.T) {
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+	dataID := "1234ID"
+	origStr := `{"data":{"dataId":"1234ID", "metadata":{"name":"old", "description":"keep",
+		"metadata":{"a":"1", "b":"2"}, "textKeyValueList":[{"key":"k1", "value":"v1"}, {"key":"k2", "value":"v2"}]}}}`
+	changedStr := `{"data":{"dataId":"1234ID", "metadata":{"name":"someone else"}}}`
+
+	newName := "new"
+	patch := MetaPatch{
+		Name:         &newName,
+		SetMeta:      map[string]string{"c": "3"},
+		DeleteMeta:   []string{"a"},
+		SetTxtKeyVal: []TxtKeyVal{{Key: "k2", Value: "v2b"}},
+		DeleteKeyVal: []string{"k1"}}
+
+	SetMockClient([]string{origStr, origStr, `{}`}, 200)
+	desc, err := PatchFileMeta(dataID, url, authKey, patch)
+	if err != nil {
+		t.Fatal(`TestPatchFileMeta: error: ` + err.Error())
+	}
+	meta := desc.ResMeta
+	if meta.Name != "new" || meta.Description != "keep" || len(meta.Metadata) != 2 || meta.Metadata["b"] != "2" ||
+		meta.Metadata["c"] != "3" || len(meta.TxtKeyValList) != 1 || meta.TxtKeyValList[0].Value != "v2b" {
+		t.Errorf(`TestPatchFileMeta: patch applied incorrectly: %#v`, meta)
+	}
+
+	SetMockClient([]string{origStr, changedStr}, 200)
+	_, err = PatchFileMeta(dataID, url, authKey, patch)
+	if _, ok := err.(MetaConflictError); !ok {
+		t.Errorf(`TestPatchFileMeta: conflict not detected: %v`, err)
+	}
+
+	// deletions must reach Pz, even when they leave fields empty
+	prev := HTTPClient()
+	defer SetHTTPClient(prev)
+	oneStr := `{"data":{"dataId":"1234ID", "metadata":{"name":"n", "description":"d",
+		"metadata":{"a":"1"}, "textKeyValueList":[{"key":"k1", "value":"v1"}]}}}`
+	var posted string
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := oneStr
+		if req.Method == "POST" {
+			byts, _ := ioutil.ReadAll(req.Body)
+			posted, body = string(byts), `{}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header)}, nil
+	})})
+	empty := ""
+	patch = MetaPatch{Description: &empty, DeleteMeta: []string{"a"}, DeleteKeyVal: []string{"k1"}}
+	if _, err = PatchFileMeta(dataID, url, authKey, patch); err != nil {
+		t.Fatal(`TestPatchFileMeta: error on deletion: ` + err.Error())
+	}
+	expected := `{"description":"","metadata":{},"numericKeyValueList":[],"textKeyValueList":[]}`
+	if posted != expected {
+		t.Errorf(`TestPatchFileMeta: posted %s, expected %s`, posted, expected)
+	}
+}
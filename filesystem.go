@@ -0,0 +1,164 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileSystem is the minimal set of file operations that the download and
+// ingest functions need.  It exists so that those functions can be pointed
+// at something other than the current working directory - an absolute
+// path, a temp directory, or an in-memory filesystem for testing.
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+}
+
+// DirFS is a FileSystem backed by the local disk.  Names are resolved
+// against the directory it names, which may itself be absolute or relative
+// to the current working directory.  Names must stay within that directory:
+// absolute names, and names that climb out of it through ".." or symlinks,
+// are rejected.  The empty DirFS refers to the current working directory.
+type DirFS string
+
+// Open opens the named file for reading.
+func (d DirFS) Open(name string) (io.ReadCloser, error) {
+	root, err := d.root("open", name)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.Open(name)
+}
+
+// Create creates or truncates the named file for writing.
+func (d DirFS) Create(name string) (io.WriteCloser, error) {
+	root, err := d.root("create", name)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.Create(name)
+}
+
+// root opens the directory of the DirFS, after checking that the name is
+// one that belongs within it.  Files opened through the root remain open
+// after it is closed.
+func (d DirFS) root(op, name string) (*os.Root, error) {
+	if !filepath.IsLocal(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir := string(d)
+	if dir == "" {
+		dir = "."
+	}
+	return os.OpenRoot(dir)
+}
+
+// ReadOnlyFS wraps an io/fs filesystem (such as os.DirFS or fstest.MapFS)
+// as a FileSystem.  Calls to Create on the result will fail.
+func ReadOnlyFS(fsys fs.FS) FileSystem {
+	return readOnlyFS{fsys}
+}
+
+type readOnlyFS struct{ fsys fs.FS }
+
+func (r readOnlyFS) Open(name string) (io.ReadCloser, error) {
+	return r.fsys.Open(name)
+}
+
+func (r readOnlyFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+}
+
+// MemFS is a simple in-memory FileSystem, primarily intended for testing.
+// Files written through Create become visible when the writer is closed.
+// It is safe for concurrent use.
+type MemFS struct {
+	lock  sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string][]byte)}
+}
+
+// Open opens the named file for reading.
+func (m *MemFS) Open(name string) (io.ReadCloser, error) {
+	byts, err := m.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(byts)), nil
+}
+
+// Create returns a writer for the named file.  The file contents are
+// replaced when the writer is closed.
+func (m *MemFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{name: name, owner: m}, nil
+}
+
+// ReadFile returns a copy of the contents of the named file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	byts, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), byts...), nil
+}
+
+// WriteFile sets the contents of the named file.
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[name] = append([]byte(nil), data...)
+}
+
+// Names returns the sorted list of files currently in the MemFS.
+func (m *MemFS) Names() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memFile struct {
+	bytes.Buffer
+	name  string
+	owner *MemFS
+}
+
+func (f *memFile) Close() error {
+	f.owner.WriteFile(f.name, f.Bytes())
+	return nil
+}
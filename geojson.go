@@ -0,0 +1,358 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+/*
+This is a typed model of GeoJSON (RFC 7946), along with enough validation
+to catch malformed input before it gets sent off to Pz.  Coordinates are
+kept in the nested float slices that match their geometry type - []float64
+for a Point, [][]float64 for a LineString or MultiPoint, and so forth - so
+that callers can type-assert them directly.
+*/
+
+// Geometry is a GeoJSON geometry object.  Coordinates is nil for
+// GeometryCollections, which use Geometries instead.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates,omitempty"`
+	Geometries  []*Geometry `json:"geometries,omitempty"`
+	BBox        []float64   `json:"bbox,omitempty"`
+}
+
+// Feature is a GeoJSON Feature.  Geometry may legitimately be nil.
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
+	Geometry   *Geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+	BBox       []float64              `json:"bbox,omitempty"`
+}
+
+// FeatureCollection is a GeoJSON FeatureCollection.
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+	BBox     []float64  `json:"bbox,omitempty"`
+}
+
+// UnmarshalJSON decodes a geometry, converting its coordinates into the
+// slice type appropriate to its geometry type.
+func (g *Geometry) UnmarshalJSON(byts []byte) error {
+	var raw struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+		Geometries  []*Geometry     `json:"geometries"`
+		BBox        []float64       `json:"bbox"`
+	}
+	if err := json.Unmarshal(byts, &raw); err != nil {
+		return err
+	}
+	g.Type, g.Geometries, g.BBox, g.Coordinates = raw.Type, raw.Geometries, raw.BBox, nil
+	if len(raw.Coordinates) == 0 || string(raw.Coordinates) == "null" {
+		return nil
+	}
+
+	var err error
+	switch raw.Type {
+	case "Point":
+		var coords []float64
+		err = json.Unmarshal(raw.Coordinates, &coords)
+		g.Coordinates = coords
+	case "MultiPoint", "LineString":
+		var coords [][]float64
+		err = json.Unmarshal(raw.Coordinates, &coords)
+		g.Coordinates = coords
+	case "MultiLineString", "Polygon":
+		var coords [][][]float64
+		err = json.Unmarshal(raw.Coordinates, &coords)
+		g.Coordinates = coords
+	case "MultiPolygon":
+		var coords [][][][]float64
+		err = json.Unmarshal(raw.Coordinates, &coords)
+		g.Coordinates = coords
+	default:
+		var coords interface{}
+		err = json.Unmarshal(raw.Coordinates, &coords)
+		g.Coordinates = coords
+	}
+	if err != nil {
+		return fmt.Errorf("invalid coordinates for %s: %s", raw.Type, err.Error())
+	}
+	return nil
+}
+
+// ParseGeoJSON decodes and validates the given GeoJSON.  FeatureCollections
+// are returned as-is.  A lone Feature or Geometry is wrapped in a
+// FeatureCollection, so that callers only have one shape to deal with.
+func ParseGeoJSON(byts []byte) (*FeatureCollection, error) {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(byts, &probe); err != nil {
+		return nil, ErrWithTrace("GeoJSON could not be parsed: " + err.Error())
+	}
+
+	var fc FeatureCollection
+	switch probe.Type {
+	case "FeatureCollection":
+		if err := json.Unmarshal(byts, &fc); err != nil {
+			return nil, ErrWithTrace("GeoJSON could not be parsed: " + err.Error())
+		}
+	case "Feature":
+		var feat Feature
+		if err := json.Unmarshal(byts, &feat); err != nil {
+			return nil, ErrWithTrace("GeoJSON could not be parsed: " + err.Error())
+		}
+		fc = FeatureCollection{Type: "FeatureCollection", Features: []*Feature{&feat}}
+	case "":
+		return nil, ErrWithTrace(`GeoJSON object has no "type".`)
+	default:
+		var geom Geometry
+		if err := json.Unmarshal(byts, &geom); err != nil {
+			return nil, ErrWithTrace("GeoJSON could not be parsed: " + err.Error())
+		}
+		fc = FeatureCollection{Type: "FeatureCollection", Features: []*Feature{{Type: "Feature", Geometry: &geom}}}
+	}
+
+	if err := fc.Validate(); err != nil {
+		return nil, TraceErr(err)
+	}
+	return &fc, nil
+}
+
+// Validate checks that the FeatureCollection and all of its features are
+// well-formed.
+func (fc *FeatureCollection) Validate() error {
+	if fc.Type != "FeatureCollection" {
+		return ErrWithTrace(`Expected type "FeatureCollection", got "` + fc.Type + `".`)
+	}
+	for i, feat := range fc.Features {
+		if feat == nil {
+			return ErrWithTrace(fmt.Sprintf("Feature %d is null.", i))
+		}
+		if err := feat.Validate(); err != nil {
+			return ErrWithTrace(fmt.Sprintf("Feature %d: %s", i, err.Error()))
+		}
+	}
+	return nil
+}
+
+// Validate checks that the Feature and its geometry are well-formed.
+func (f *Feature) Validate() error {
+	if f.Type != "Feature" {
+		return fmt.Errorf(`expected type "Feature", got "%s"`, f.Type)
+	}
+	if f.Geometry == nil {
+		return nil
+	}
+	return f.Geometry.Validate()
+}
+
+// Validate checks that the geometry has a known type, and coordinates of
+// the right shape and size for that type.
+func (g *Geometry) Validate() error {
+	if g.Type == "GeometryCollection" {
+		for i, sub := range g.Geometries {
+			if sub == nil {
+				return fmt.Errorf("geometry %d of GeometryCollection is null", i)
+			}
+			if err := sub.Validate(); err != nil {
+				return fmt.Errorf("geometry %d of GeometryCollection: %s", i, err.Error())
+			}
+		}
+		return nil
+	}
+
+	switch coords := g.Coordinates.(type) {
+	case []float64:
+		if g.Type == "Point" {
+			return validPosition(coords)
+		}
+	case [][]float64:
+		switch g.Type {
+		case "MultiPoint":
+			return validPositions(coords, 0)
+		case "LineString":
+			return validPositions(coords, 2)
+		}
+	case [][][]float64:
+		switch g.Type {
+		case "MultiLineString":
+			for _, line := range coords {
+				if err := validPositions(line, 2); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "Polygon":
+			return validPolygon(coords)
+		}
+	case [][][][]float64:
+		if g.Type == "MultiPolygon" {
+			for _, poly := range coords {
+				if err := validPolygon(poly); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case nil:
+		return fmt.Errorf("%s has no coordinates", g.Type)
+	}
+	return fmt.Errorf(`unknown or malformed geometry of type "%s"`, g.Type)
+}
+
+func validPosition(pos []float64) error {
+	if len(pos) < 2 {
+		return fmt.Errorf("position has %d values, expected at least 2", len(pos))
+	}
+	for _, val := range pos {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return fmt.Errorf("position contains a non-finite value")
+		}
+	}
+	return nil
+}
+
+func validPositions(positions [][]float64, minLen int) error {
+	if len(positions) < minLen {
+		return fmt.Errorf("expected at least %d positions, got %d", minLen, len(positions))
+	}
+	for _, pos := range positions {
+		if err := validPosition(pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validPolygon(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("polygon has no rings")
+	}
+	for _, ring := range rings {
+		if err := validPositions(ring, 4); err != nil {
+			return err
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("polygon ring is not closed")
+		}
+	}
+	return nil
+}
+
+// Extent returns the bounding box of the geometry as minX, minY, maxX,
+// maxY.  The final return is false if the geometry has no positions.
+func (g *Geometry) Extent() (float64, float64, float64, float64, bool) {
+	ext := newExtent()
+	ext.addGeometry(g)
+	return ext.minX, ext.minY, ext.maxX, ext.maxY, ext.found
+}
+
+// Extent returns the bounding box of all features in the collection as
+// minX, minY, maxX, maxY.  The final return is false if there are no
+// positions in the collection at all.
+func (fc *FeatureCollection) Extent() (float64, float64, float64, float64, bool) {
+	ext := newExtent()
+	for _, feat := range fc.Features {
+		if feat != nil {
+			ext.addGeometry(feat.Geometry)
+		}
+	}
+	return ext.minX, ext.minY, ext.maxX, ext.maxY, ext.found
+}
+
+// FeatureCount returns the number of features in the collection.
+func (fc *FeatureCollection) FeatureCount() int {
+	return len(fc.Features)
+}
+
+// SpatMeta builds the spatial metadata for the collection - its extent and
+// feature count.  GeoJSON is always EPSG:4326.
+func (fc *FeatureCollection) SpatMeta() *SpatMeta {
+	sMeta := SpatMeta{EpsgCode: 4326, NumFeatures: fc.FeatureCount()}
+	if minX, minY, maxX, maxY, ok := fc.Extent(); ok {
+		sMeta.MinX, sMeta.MinY, sMeta.MaxX, sMeta.MaxY = minX, minY, maxX, maxY
+	}
+	return &sMeta
+}
+
+type extent struct {
+	minX, minY, maxX, maxY float64
+	found                  bool
+}
+
+func newExtent() *extent {
+	return &extent{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1), false}
+}
+
+func (e *extent) addPosition(pos []float64) {
+	if len(pos) < 2 {
+		return
+	}
+	e.minX, e.maxX = math.Min(e.minX, pos[0]), math.Max(e.maxX, pos[0])
+	e.minY, e.maxY = math.Min(e.minY, pos[1]), math.Max(e.maxY, pos[1])
+	e.found = true
+}
+
+func (e *extent) addGeometry(g *Geometry) {
+	if g == nil {
+		return
+	}
+	switch coords := g.Coordinates.(type) {
+	case []float64:
+		e.addPosition(coords)
+	case [][]float64:
+		for _, pos := range coords {
+			e.addPosition(pos)
+		}
+	case [][][]float64:
+		for _, line := range coords {
+			for _, pos := range line {
+				e.addPosition(pos)
+			}
+		}
+	case [][][][]float64:
+		for _, poly := range coords {
+			for _, ring := range poly {
+				for _, pos := range ring {
+					e.addPosition(pos)
+				}
+			}
+		}
+	}
+	for _, sub := range g.Geometries {
+		e.addGeometry(sub)
+	}
+}
+
+// ValidateGeoJSONIngest is the default IngestValidator for "geojson" ingests.
+// It rejects malformed GeoJSON, and fills in the spatial metadata (extent and
+// feature count) of the ingest request.
+func ValidateGeoJSONIngest(ingData []byte, dRes *DataDesc) error {
+	fc, err := ParseGeoJSON(ingData)
+	if err != nil {
+		return TraceErr(err)
+	}
+	dRes.SpatMeta = fc.SpatMeta()
+	return nil
+}
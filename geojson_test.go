@@ -0,0 +1,71 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"testing"
+)
+
+func TestParseGeoJSON(t *testing.T) {
+	goodStrs := []string{
+		`{"type":"Point","coordinates":[1,2]}`,
+		`{"type":"Feature","geometry":null,"properties":{}}`,
+		`{"type":"FeatureCollection","features":[
+			{"type":"Feature","geometry":{"type":"LineString","coordinates":[[-3,0],[4,5]]},"properties":{"a":1}},
+			{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,-6],[0,0]]]},"properties":{}},
+			{"type":"Feature","geometry":{"type":"GeometryCollection","geometries":[{"type":"MultiPoint","coordinates":[[2,2]]}]},"properties":{}}]}`}
+	badStrs := []string{
+		`tempTestFile.tmp`,
+		`{"coordinates":[1,2]}`,
+		`{"type":"Point","coordinates":[1]}`,
+		`{"type":"Point","coordinates":[[1,2]]}`,
+		`{"type":"LineString","coordinates":[[1,2]]}`,
+		`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+		`{"type":"Blob","coordinates":[1,2]}`,
+		`{"type":"FeatureCollection","features":[{"type":"Thing"}]}`}
+
+	for i, str := range goodStrs {
+		if _, err := ParseGeoJSON([]byte(str)); err != nil {
+			t.Errorf(`TestParseGeoJSON: failed on good input %d: %s`, i, err.Error())
+		}
+	}
+	for i, str := range badStrs {
+		if _, err := ParseGeoJSON([]byte(str)); err == nil {
+			t.Errorf(`TestParseGeoJSON: passed on bad input %d.`, i)
+		}
+	}
+
+	fc, _ := ParseGeoJSON([]byte(goodStrs[2]))
+	sMeta := fc.SpatMeta()
+	if sMeta.NumFeatures != 3 || sMeta.MinX != -3 || sMeta.MinY != -6 || sMeta.MaxX != 4 || sMeta.MaxY != 5 {
+		t.Errorf(`TestParseGeoJSON: bad spatial metadata: %#v`, sMeta)
+	}
+}
+
+func TestValidateGeoJSONIngest(t *testing.T) {
+	var dRes DataDesc
+	if err := ValidateGeoJSONIngest([]byte(`{"type":"Point","coordinates":[1,2]}`), &dRes); err != nil {
+		t.Error(`TestValidateGeoJSONIngest: failed on good input: ` + err.Error())
+	}
+	if dRes.SpatMeta == nil || dRes.SpatMeta.NumFeatures != 1 {
+		t.Errorf(`TestValidateGeoJSONIngest: spatial metadata not filled: %#v`, dRes.SpatMeta)
+	}
+
+	SetMockClient(nil, 250)
+	_, err := Ingest("test.geojson", "geojson", "http://testURL.net", "tester", "0.0", "testAuthKey", []byte(`{"type":`), nil)
+	if err == nil {
+		t.Error(`TestValidateGeoJSONIngest: Ingest passed on malformed GeoJSON.`)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+/*
+This is a minimal reader for the header of a (Geo)TIFF file - enough to tell
+whether something is actually a TIFF, and to pull out the size, band count,
+projection and extent of the image in its first IFD.  It does not decode
+pixel data.  Only georeferencing through ModelPixelScale and ModelTiepoint
+is understood, which covers what GDAL writes for north-up images.
+*/
+
+// TIFF tags and GeoKeys of interest.
+const (
+	tiffTagImageWidth      = 256
+	tiffTagImageLength     = 257
+	tiffTagSamplesPerPixel = 277
+	tiffTagPixelScale      = 33550
+	tiffTagTiepoint        = 33922
+	tiffTagGeoKeyDir       = 34735
+
+	geoKeyGeographicType = 2048
+	geoKeyProjectedType  = 3072
+	geoKeyUserDefined    = 32767
+)
+
+// tiffTypeSizes maps TIFF field types to their size in bytes.
+var tiffTypeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 16: 8, 17: 8, 18: 8,
+}
+
+// TiffInfo is what ParseTiffHeader learns about a TIFF.  PixelScale and
+// Tiepoint are nil, and EpsgCode zero, if the TIFF is not georeferenced.
+type TiffInfo struct {
+	BigTIFF    bool
+	Width      int
+	Height     int
+	Bands      int
+	EpsgCode   int
+	PixelScale []float64 // ScaleX, ScaleY, ScaleZ
+	Tiepoint   []float64 // I, J, K, X, Y, Z
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+	big   bool
+}
+
+// ParseTiffHeader reads the header and first IFD of the given TIFF or
+// BigTIFF file.  It fails if the data is not a TIFF.
+func ParseTiffHeader(data []byte) (*TiffInfo, error) {
+	if len(data) < 8 {
+		return nil, ErrWithTrace("Data too short to be a TIFF.")
+	}
+
+	tr := tiffReader{data: data}
+	switch string(data[0:2]) {
+	case "II":
+		tr.order = binary.LittleEndian
+	case "MM":
+		tr.order = binary.BigEndian
+	default:
+		return nil, ErrWithTrace("Data is not a TIFF: bad byte order mark.")
+	}
+
+	var ifdOffset uint64
+	switch tr.order.Uint16(data[2:4]) {
+	case 42:
+		ifdOffset = uint64(tr.order.Uint32(data[4:8]))
+	case 43:
+		if len(data) < 16 || tr.order.Uint16(data[4:6]) != 8 {
+			return nil, ErrWithTrace("Data is not a TIFF: malformed BigTIFF header.")
+		}
+		tr.big = true
+		ifdOffset = tr.order.Uint64(data[8:16])
+	default:
+		return nil, ErrWithTrace("Data is not a TIFF: bad magic number.")
+	}
+
+	tags, err := tr.readIFD(ifdOffset)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+
+	info := TiffInfo{BigTIFF: tr.big, Bands: 1}
+	if vals := tags[tiffTagImageWidth]; len(vals) > 0 {
+		info.Width = int(vals[0])
+	}
+	if vals := tags[tiffTagImageLength]; len(vals) > 0 {
+		info.Height = int(vals[0])
+	}
+	if vals := tags[tiffTagSamplesPerPixel]; len(vals) > 0 {
+		info.Bands = int(vals[0])
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil, ErrWithTrace("TIFF does not specify image dimensions.")
+	}
+	if vals := tags[tiffTagPixelScale]; len(vals) >= 2 {
+		info.PixelScale = vals
+	}
+	if vals := tags[tiffTagTiepoint]; len(vals) >= 6 {
+		info.Tiepoint = vals[:6]
+	}
+	info.EpsgCode = geoKeyEpsg(tags[tiffTagGeoKeyDir])
+
+	return &info, nil
+}
+
+// readIFD reads the numeric values of the tags we care about from the IFD
+// at the given offset.
+func (tr *tiffReader) readIFD(offset uint64) (map[uint16][]float64, error) {
+	var (
+		count     uint64
+		entrySize uint64 = 12
+		valSize   uint64 = 4
+	)
+	if tr.big {
+		entrySize, valSize = 20, 8
+		if !tr.inBounds(offset, 8) {
+			return nil, ErrWithTrace("TIFF IFD offset out of bounds.")
+		}
+		count = tr.order.Uint64(tr.data[offset:])
+		offset += 8
+	} else {
+		if !tr.inBounds(offset, 2) {
+			return nil, ErrWithTrace("TIFF IFD offset out of bounds.")
+		}
+		count = uint64(tr.order.Uint16(tr.data[offset:]))
+		offset += 2
+	}
+	// count is checked before multiplying, as a huge one could wrap around
+	if count > (uint64(len(tr.data))-offset)/entrySize {
+		return nil, ErrWithTrace("TIFF IFD runs past end of data.")
+	}
+
+	tags := make(map[uint16][]float64)
+	for i := uint64(0); i < count; i++ {
+		entry := tr.data[offset+i*entrySize:]
+		tag := tr.order.Uint16(entry[0:2])
+		switch tag {
+		case tiffTagImageWidth, tiffTagImageLength, tiffTagSamplesPerPixel,
+			tiffTagPixelScale, tiffTagTiepoint, tiffTagGeoKeyDir:
+		default:
+			continue
+		}
+		fType := tr.order.Uint16(entry[2:4])
+		typeSize, ok := tiffTypeSizes[fType]
+		if !ok {
+			continue
+		}
+		var valCount, valOffset uint64
+		if tr.big {
+			valCount = tr.order.Uint64(entry[4:12])
+			valOffset = offset + i*entrySize + 12
+		} else {
+			valCount = uint64(tr.order.Uint32(entry[4:8]))
+			valOffset = offset + i*entrySize + 8
+		}
+		if valCount > uint64(len(tr.data)) {
+			return nil, ErrWithTrace("TIFF tag " + strconv.Itoa(int(tag)) + " has an impossible value count.")
+		}
+		if valCount*typeSize > valSize {
+			if tr.big {
+				valOffset = tr.order.Uint64(tr.data[valOffset:])
+			} else {
+				valOffset = uint64(tr.order.Uint32(tr.data[valOffset:]))
+			}
+		}
+		if !tr.inBounds(valOffset, valCount*typeSize) {
+			return nil, ErrWithTrace("TIFF tag " + strconv.Itoa(int(tag)) + " runs past end of data.")
+		}
+		tags[tag] = tr.readValues(fType, valOffset, valCount)
+	}
+	return tags, nil
+}
+
+func (tr *tiffReader) inBounds(offset, length uint64) bool {
+	return offset <= uint64(len(tr.data)) && length <= uint64(len(tr.data))-offset
+}
+
+// readValues reads count values of the given TIFF type as float64s.
+// Bounds must already have been checked.
+func (tr *tiffReader) readValues(fType uint16, offset, count uint64) []float64 {
+	vals := make([]float64, count)
+	size := tiffTypeSizes[fType]
+	for i := range vals {
+		b := tr.data[offset+uint64(i)*size:]
+		switch fType {
+		case 1, 2, 7:
+			vals[i] = float64(b[0])
+		case 6:
+			vals[i] = float64(int8(b[0]))
+		case 3:
+			vals[i] = float64(tr.order.Uint16(b))
+		case 8:
+			vals[i] = float64(int16(tr.order.Uint16(b)))
+		case 4:
+			vals[i] = float64(tr.order.Uint32(b))
+		case 9:
+			vals[i] = float64(int32(tr.order.Uint32(b)))
+		case 5:
+			vals[i] = float64(tr.order.Uint32(b)) / float64(tr.order.Uint32(b[4:]))
+		case 10:
+			vals[i] = float64(int32(tr.order.Uint32(b))) / float64(int32(tr.order.Uint32(b[4:])))
+		case 11:
+			vals[i] = float64(math.Float32frombits(tr.order.Uint32(b)))
+		case 12:
+			vals[i] = math.Float64frombits(tr.order.Uint64(b))
+		case 16, 18:
+			vals[i] = float64(tr.order.Uint64(b))
+		case 17:
+			vals[i] = float64(int64(tr.order.Uint64(b)))
+		}
+	}
+	return vals
+}
+
+// geoKeyEpsg pulls the EPSG code out of a GeoKeyDirectory.  Projected
+// codes take priority over geographic ones.  Returns zero if there is no
+// usable code.
+func geoKeyEpsg(dir []float64) int {
+	if len(dir) < 4 {
+		return 0
+	}
+	var projected, geographic int
+	numKeys := int(dir[3])
+	for i := 0; i < numKeys && 4+i*4+3 < len(dir); i++ {
+		key := dir[4+i*4 : 4+i*4+4]
+		if key[1] != 0 { // value stored in another tag; not a plain code
+			continue
+		}
+		switch int(key[0]) {
+		case geoKeyProjectedType:
+			projected = int(key[3])
+		case geoKeyGeographicType:
+			geographic = int(key[3])
+		}
+	}
+	if projected != 0 && projected != geoKeyUserDefined {
+		return projected
+	}
+	if geographic != 0 && geographic != geoKeyUserDefined {
+		return geographic
+	}
+	return 0
+}
+
+// SpatMeta builds spatial metadata for the TIFF from its georeferencing.
+// Returns nil if the TIFF lacks the pixel scale or tiepoint needed to
+// compute an extent.
+func (info *TiffInfo) SpatMeta() *SpatMeta {
+	if info.PixelScale == nil || info.Tiepoint == nil {
+		return nil
+	}
+	scaleX, scaleY := info.PixelScale[0], info.PixelScale[1]
+	minX := info.Tiepoint[3] - info.Tiepoint[0]*scaleX
+	maxY := info.Tiepoint[4] + info.Tiepoint[1]*scaleY
+	sMeta := SpatMeta{
+		EpsgCode: info.EpsgCode,
+		MinX:     minX,
+		MaxX:     minX + float64(info.Width)*scaleX,
+		MinY:     maxY - float64(info.Height)*scaleY,
+		MaxY:     maxY}
+	if info.EpsgCode != 0 {
+		sMeta.CoordRefSystem = "EPSG:" + strconv.Itoa(info.EpsgCode)
+	}
+	return &sMeta
+}
+
+// ValidateRasterIngest is the default IngestValidator for "raster" ingests.
+// It rejects anything that is not a TIFF, and fills in the MIME type and,
+// where the TIFF is georeferenced, the spatial metadata of the ingest request.
+func ValidateRasterIngest(ingData []byte, dRes *DataDesc) error {
+	info, err := ParseTiffHeader(ingData)
+	if err != nil {
+		return TraceErr(err)
+	}
+	dRes.DataType.MimeType = "image/tiff"
+	if sMeta := info.SpatMeta(); sMeta != nil {
+		dRes.SpatMeta = sMeta
+	}
+	return nil
+}
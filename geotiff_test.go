@@ -0,0 +1,135 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type testTiffTag struct {
+	tag   uint16
+	fType uint16
+	vals  interface{} // []uint16 or []float64
+}
+
+// buildTestTiff builds a minimal georeferenced TIFF, with no pixel data,
+// covering 100x50 pixels from (10, 20) at 0.5 units per pixel, in UTM 33N.
+func buildTestTiff(big bool, order binary.ByteOrder) []byte {
+	tags := []testTiffTag{
+		{tiffTagImageWidth, 3, []uint16{100}},
+		{tiffTagImageLength, 3, []uint16{50}},
+		{tiffTagSamplesPerPixel, 3, []uint16{3}},
+		{tiffTagPixelScale, 12, []float64{0.5, 0.5, 0}},
+		{tiffTagTiepoint, 12, []float64{0, 0, 0, 10, 20, 0}},
+		{tiffTagGeoKeyDir, 3, []uint16{1, 1, 0, 2, 1024, 0, 1, 1, geoKeyProjectedType, 0, 1, 32633}}}
+
+	headSize, countSize, entrySize, valSize := 8, 2, 12, 4
+	if big {
+		headSize, countSize, entrySize, valSize = 16, 8, 20, 8
+	}
+	dataStart := headSize + countSize + len(tags)*entrySize + valSize
+
+	var head, ifd, extra bytes.Buffer
+	if order == binary.LittleEndian {
+		head.WriteString("II")
+	} else {
+		head.WriteString("MM")
+	}
+	if big {
+		binary.Write(&head, order, []uint16{43, 8, 0})
+		binary.Write(&head, order, uint64(headSize))
+		binary.Write(&ifd, order, uint64(len(tags)))
+	} else {
+		binary.Write(&head, order, uint16(42))
+		binary.Write(&head, order, uint32(headSize))
+		binary.Write(&ifd, order, uint16(len(tags)))
+	}
+
+	for _, tag := range tags {
+		var valBuf bytes.Buffer
+		binary.Write(&valBuf, order, tag.vals)
+		count := valBuf.Len() / int(tiffTypeSizes[tag.fType])
+		binary.Write(&ifd, order, []uint16{tag.tag, tag.fType})
+		if big {
+			binary.Write(&ifd, order, uint64(count))
+		} else {
+			binary.Write(&ifd, order, uint32(count))
+		}
+		if valBuf.Len() <= valSize {
+			ifd.Write(valBuf.Bytes())
+			ifd.Write(make([]byte, valSize-valBuf.Len()))
+			continue
+		}
+		if big {
+			binary.Write(&ifd, order, uint64(dataStart+extra.Len()))
+		} else {
+			binary.Write(&ifd, order, uint32(dataStart+extra.Len()))
+		}
+		extra.Write(valBuf.Bytes())
+	}
+	ifd.Write(make([]byte, valSize)) // no next IFD
+
+	return append(append(head.Bytes(), ifd.Bytes()...), extra.Bytes()...)
+}
+
+func TestParseTiffHeader(t *testing.T) {
+	for _, big := range []bool{false, true} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			info, err := ParseTiffHeader(buildTestTiff(big, order))
+			if err != nil {
+				t.Errorf(`TestParseTiffHeader: error (big: %v, order: %v): %s`, big, order, err.Error())
+				continue
+			}
+			if info.BigTIFF != big || info.Width != 100 || info.Height != 50 || info.Bands != 3 || info.EpsgCode != 32633 {
+				t.Errorf(`TestParseTiffHeader: bad info (big: %v, order: %v): %#v`, big, order, info)
+			}
+			sMeta := info.SpatMeta()
+			if sMeta == nil || sMeta.MinX != 10 || sMeta.MaxX != 60 || sMeta.MinY != -5 || sMeta.MaxY != 20 {
+				t.Errorf(`TestParseTiffHeader: bad extent (big: %v, order: %v): %#v`, big, order, sMeta)
+			}
+		}
+	}
+
+	// a BigTIFF IFD whose entry count wraps around when multiplied by the
+	// entry size
+	var wrapped bytes.Buffer
+	wrapped.WriteString("II")
+	binary.Write(&wrapped, binary.LittleEndian, []uint16{43, 8, 0})
+	binary.Write(&wrapped, binary.LittleEndian, []uint64{16, 0x0CCCCCCCCCCCCCCD})
+	wrapped.Write(make([]byte, 4))
+
+	good := buildTestTiff(false, binary.LittleEndian)
+	badInputs := [][]byte{[]byte("tempTestFile.tmp"), []byte("II*"), good[:20], append([]byte("XX"), good[2:]...), wrapped.Bytes()}
+	for i, bad := range badInputs {
+		if _, err := ParseTiffHeader(bad); err == nil {
+			t.Errorf(`TestParseTiffHeader: passed on bad input %d.`, i)
+		}
+	}
+}
+
+func TestValidateRasterIngest(t *testing.T) {
+	var dRes DataDesc
+	if err := ValidateRasterIngest(buildTestTiff(false, binary.LittleEndian), &dRes); err != nil {
+		t.Fatal(`TestValidateRasterIngest: failed on good input: ` + err.Error())
+	}
+	if dRes.DataType.MimeType != "image/tiff" || dRes.SpatMeta == nil || dRes.SpatMeta.EpsgCode != 32633 {
+		t.Errorf(`TestValidateRasterIngest: request not filled: %#v`, dRes)
+	}
+	if err := ValidateRasterIngest([]byte("not a tiff"), &dRes); err == nil {
+		t.Error(`TestValidateRasterIngest: passed on non-TIFF.`)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !go1.24
+
+package pzsvc
+
+// DirFS opens files through os.Root, which arrived in Go 1.24.  Older
+// toolchains stop here, with the message below, rather than somewhere less
+// obvious.
+var _ = pzsvc_lib_requires_go_1_24_or_later
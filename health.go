@@ -0,0 +1,269 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+A Health holds a set of named checks on the things a service depends on,
+runs them (concurrently, each under a timeout) when asked, and caches the
+result for a while, so that frequent probes do not turn into a steady load
+on the gateway.  Checks run detached from the context of whoever asked, so
+that a probe that hangs up early neither cuts them short nor leaves its
+cancellation cached as the service's health.  Checks are either critical, in which case their failure
+makes the service unready, or not, in which case it only marks the service
+as degraded.
+*/
+
+// The possible values of HealthStatus.Status and CheckStatus.Status.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthFail     = "fail"
+)
+
+// HealthCheck checks a single dependency, returning an error if it is
+// unavailable.  It should give up when the context ends.
+type HealthCheck func(ctx context.Context) error
+
+// CheckStatus is the outcome of a single HealthCheck.
+type CheckStatus struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthStatus is the aggregated outcome of all the checks of a Health.
+type HealthStatus struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]CheckStatus `json:"checks"`
+	CheckedOn time.Time              `json:"checkedOn"`
+}
+
+type healthEntry struct {
+	check    HealthCheck
+	critical bool
+}
+
+// Health is a registry of health checks, with a cached aggregate result.
+type Health struct {
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	lock    sync.Mutex
+	checks  map[string]healthEntry
+	last    *HealthStatus
+	running *healthRun
+}
+
+// healthRun is a single run of all the checks, shared by all callers that
+// ask while it is under way.  status is set before done is closed.
+type healthRun struct {
+	done   chan struct{}
+	status HealthStatus
+}
+
+// NewHealth creates a Health that caches its results for cacheTTL, and gives
+// each check up to timeout to complete.
+func NewHealth(cacheTTL, timeout time.Duration) *Health {
+	return &Health{cacheTTL: cacheTTL, timeout: timeout, checks: make(map[string]healthEntry)}
+}
+
+// Register adds a check under the given name, replacing any check already
+// registered under that name.
+func (h *Health) Register(name string, check HealthCheck, critical bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.checks[name] = healthEntry{check, critical}
+	h.last = nil
+	h.running = nil
+}
+
+// Check returns the aggregated status of all registered checks, running
+// them if the cached result has expired.  Concurrent callers share a single
+// run.  The checks run under a context detached from ctx, keeping only its
+// values (such as its trace); if ctx ends first, Check returns a failure
+// without waiting, and the run goes on to completion in the background.
+func (h *Health) Check(ctx context.Context) HealthStatus {
+	h.lock.Lock()
+	if h.last != nil && time.Since(h.last.CheckedOn) < h.cacheTTL {
+		status := *h.last
+		h.lock.Unlock()
+		return status
+	}
+	run := h.running
+	if run == nil {
+		run = &healthRun{done: make(chan struct{})}
+		h.running = run
+		checks := make(map[string]healthEntry, len(h.checks))
+		for name, entry := range h.checks {
+			checks[name] = entry
+		}
+		go h.run(context.WithoutCancel(ctx), run, checks)
+	}
+	h.lock.Unlock()
+
+	select {
+	case <-run.done:
+		return run.status
+	case <-ctx.Done():
+		return HealthStatus{Status: HealthFail, Checks: make(map[string]CheckStatus), CheckedOn: time.Now()}
+	}
+}
+
+// run runs the given checks and caches the result, unless the checks have
+// changed since the run started.
+func (h *Health) run(ctx context.Context, run *healthRun, checks map[string]healthEntry) {
+	status := HealthStatus{Status: HealthOK, Checks: make(map[string]CheckStatus), CheckedOn: time.Now()}
+	var (
+		wg        sync.WaitGroup
+		statsLock sync.Mutex
+	)
+	for name, entry := range checks {
+		wg.Add(1)
+		go func(name string, entry healthEntry) {
+			defer wg.Done()
+			result := h.runCheck(ctx, entry)
+			statsLock.Lock()
+			defer statsLock.Unlock()
+			status.Checks[name] = result
+			if result.Status == HealthFail {
+				if entry.critical {
+					status.Status = HealthFail
+				} else if status.Status == HealthOK {
+					status.Status = HealthDegraded
+				}
+			}
+		}(name, entry)
+	}
+	wg.Wait()
+
+	run.status = status
+	h.lock.Lock()
+	if h.running == run {
+		h.last = &status
+		h.running = nil
+	}
+	h.lock.Unlock()
+	close(run.done)
+}
+
+func (h *Health) runCheck(ctx context.Context, entry healthEntry) CheckStatus {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := runWithContext(ctx, func() error { return entry.check(ctx) })
+	result := CheckStatus{Status: HealthOK, Critical: entry.critical, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status, result.Error = HealthFail, err.Error()
+	}
+	return result
+}
+
+// runWithContext runs the given function, returning early with the
+// context's error if the context ends first.  The function is left to
+// finish in the background.
+func runWithContext(ctx context.Context, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- fn() }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handler returns an http.Handler that reports the aggregated status as
+// JSON - with a 200 if all critical checks pass, and a 503 otherwise.  It
+// is intended as a readiness probe.
+func (h *Health) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := h.Check(r.Context())
+		code := http.StatusOK
+		if status.Status == HealthFail {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		PrintJSON(w, status, code)
+	})
+}
+
+// LivenessHandler is an http.Handler that reports only that the process is
+// up and serving.  It checks no dependencies.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	HTTPOut(w, `{"status":"`+HealthOK+`"}`, http.StatusOK)
+}
+
+// GatewayCheck returns a HealthCheck that the Pz gateway at pzAddr is
+// reachable.
+func GatewayCheck(pzAddr string) HealthCheck {
+	return func(ctx context.Context) error {
+		resp, err := SubmitSinglePartContext(ctx, "GET", "", pzAddr, "")
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return TraceErr(err)
+	}
+}
+
+// AuthCheck returns a HealthCheck that the gateway at pzAddr accepts the
+// given authKey, as per TestPiazzaAuth.
+func AuthCheck(pzAddr, authKey string) HealthCheck {
+	return func(ctx context.Context) error {
+		return TraceErr(piazzaAuth(ctx, pzAddr, authKey))
+	}
+}
+
+// DiskSpaceCheck returns a HealthCheck that the file system holding the
+// given directory has at least minFree bytes available.  It is not
+// supported on all platforms.
+func DiskSpaceCheck(dir string, minFree uint64) HealthCheck {
+	return func(ctx context.Context) error {
+		checkDir := dir
+		if checkDir == "" {
+			checkDir = "."
+		}
+		free, err := diskFree(checkDir)
+		if err != nil {
+			return TraceErr(err)
+		}
+		if free < minFree {
+			return ErrWithTrace("Only " + strconv.FormatUint(free, 10) + " bytes free in " + checkDir + ", need " + strconv.FormatUint(minFree, 10) + ".")
+		}
+		return nil
+	}
+}
+
+// GeoServerCheck returns a HealthCheck that the GeoServer hosting the given
+// deployment answers WMS capabilities requests.
+func GeoServerCheck(depl DeplStrct, authKey string) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := GetWMSCapabilitiesContext(ctx, &depl, authKey)
+		return TraceErr(err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	var calls int32
+	health := NewHealth(time.Hour, 50*time.Millisecond)
+	health.Register("ok", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, true)
+	health.Register("optional", func(ctx context.Context) error {
+		return errors.New("optional is down")
+	}, false)
+
+	status := health.Check(context.Background())
+	if status.Status != HealthDegraded || status.Checks["ok"].Status != HealthOK ||
+		status.Checks["optional"].Error != "optional is down" {
+		t.Errorf(`TestHealth: bad status: %#v`, status)
+	}
+	health.Check(context.Background())
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf(`TestHealth: result not cached; check ran %d times.`, calls)
+	}
+
+	health.Register("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, true)
+	rec := httptest.NewRecorder()
+	health.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	var outStatus HealthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &outStatus); err != nil {
+		t.Fatal(`TestHealth: bad handler output: ` + rec.Body.String())
+	}
+	if rec.Code != http.StatusServiceUnavailable || outStatus.Status != HealthFail ||
+		outStatus.Checks["slow"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf(`TestHealth: timed-out critical check gave %d: %s`, rec.Code, rec.Body.String())
+	}
+
+	release, started := make(chan struct{}), make(chan struct{}, 1)
+	var runs int32
+	var checkErr error
+	health = NewHealth(time.Hour, time.Second)
+	health.Register("blocking", func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		started <- struct{}{}
+		<-release
+		checkErr = ctx.Err()
+		return nil
+	}, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if status = health.Check(ctx); status.Status != HealthFail {
+		t.Errorf(`TestHealth: cancelled caller gave %#v`, status)
+	}
+	close(release)
+	if status = health.Check(context.Background()); status.Status != HealthOK || checkErr != nil {
+		t.Errorf(`TestHealth: caller cancellation leaked into the run: %#v, %v`, status, checkErr)
+	}
+	if atomic.LoadInt32(&runs) != 1 {
+		t.Errorf(`TestHealth: run not shared; check ran %d times.`, runs)
+	}
+
+	rec = httptest.NewRecorder()
+	LivenessHandler(rec, httptest.NewRequest("GET", "/live", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"status":"ok"}` {
+		t.Errorf(`TestHealth: bad liveness output: %d %s`, rec.Code, rec.Body.String())
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	ctx := context.Background()
+
+	SetMockClient([]string{`{}`, `{}`}, 200)
+	if err := GatewayCheck("http://testURL.net")(ctx); err != nil {
+		t.Error(`TestHealthChecks: gateway check failed: ` + err.Error())
+	}
+	if err := AuthCheck("http://testURL.net", "testAuthKey")(ctx); err != nil {
+		t.Error(`TestHealthChecks: auth check failed: ` + err.Error())
+	}
+	SetMockClient(nil, 401)
+	if err := AuthCheck("http://testURL.net", "testAuthKey")(ctx); err == nil {
+		t.Error(`TestHealthChecks: auth check passed on 401.`)
+	}
+
+	prev := HTTPClient()
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})})
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := GatewayCheck("http://testURL.net")(shortCtx); err == nil {
+		t.Error(`TestHealthChecks: gateway check ignored its context.`)
+	}
+	if err := AuthCheck("http://testURL.net", "testAuthKey")(shortCtx); err == nil {
+		t.Error(`TestHealthChecks: auth check ignored its context.`)
+	}
+	if err := GeoServerCheck(DeplStrct{Host: "geoserver.net"}, "testAuthKey")(shortCtx); err == nil {
+		t.Error(`TestHealthChecks: GeoServer check ignored its context.`)
+	}
+	SetHTTPClient(prev)
+
+	SetMockClient([]string{`<WMS_Capabilities version="1.3.0"></WMS_Capabilities>`}, 200)
+	if err := GeoServerCheck(DeplStrct{Host: "geoserver.net"}, "testAuthKey")(ctx); err != nil {
+		t.Error(`TestHealthChecks: GeoServer check failed: ` + err.Error())
+	}
+	if err := GeoServerCheck(DeplStrct{}, "testAuthKey")(ctx); err == nil {
+		t.Error(`TestHealthChecks: GeoServer check passed without a host.`)
+	}
+
+	if _, err := diskFree("."); err == nil {
+		if err = DiskSpaceCheck(t.TempDir(), 1)(ctx); err != nil {
+			t.Error(`TestHealthChecks: disk space check failed: ` + err.Error())
+		}
+		if err = DiskSpaceCheck(t.TempDir(), 1<<62)(ctx); err == nil {
+			t.Error(`TestHealthChecks: disk space check passed on impossible minimum.`)
+		}
+		if err = DiskSpaceCheck("", 1<<62)(ctx); err == nil || !strings.Contains(err.Error(), "free in ., need") {
+			t.Errorf(`TestHealthChecks: bad message for the current directory: %v`, err)
+		}
+	}
+}
@@ -16,14 +16,13 @@ package pzsvc
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
-	"time"
 )
 
 // HTTPError represents any HTTP error
@@ -38,14 +37,17 @@ func (err HTTPError) Error() string {
 
 var httpClient *http.Client
 
-// HTTPClient is a factory method for a http.Client suitable for common operations
+// HTTPClient is a factory method for a http.Client suitable for common operations.
+// Unless SetTLSOptions or SetHTTPClient has been called, server certificates
+// are verified against the system CA pool.
 func HTTPClient() *http.Client {
 	if httpClient == nil {
-		transport := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		client, err := NewHTTPClient(CurrentTLSOptions())
+		if err != nil {
+			fmt.Println(TraceStr("Could not apply TLS options, using defaults: " + err.Error()))
+			client, _ = NewHTTPClient(TLSOptions{})
 		}
-
-		httpClient = &http.Client{Transport: transport}
+		httpClient = client
 	}
 	return httpClient
 }
@@ -62,8 +64,14 @@ func SetHTTPClient(newClient *http.Client) {
 // the get request, unmarshal the result into the given object, and return. It
 // returns the response buffer, in case it is needed for debugging purposes.
 func RequestKnownJSON(method, bodyStr, address, authKey string, outpObj interface{}) ([]byte, error) {
+	return RequestKnownJSONContext(context.Background(), method, bodyStr, address, authKey, outpObj)
+}
 
-	resp, err := SubmitSinglePart(method, bodyStr, address, authKey)
+// RequestKnownJSONContext is RequestKnownJSON within the trace of the given
+// context.  Ending the context aborts the call.
+func RequestKnownJSONContext(ctx context.Context, method, bodyStr, address, authKey string, outpObj interface{}) ([]byte, error) {
+
+	resp, err := SubmitSinglePartContext(ctx, method, bodyStr, address, authKey)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -94,6 +102,12 @@ func ReqByObjJSON(method, addr, authKey string, inpObj, outpObj interface{}) ([]
 // SubmitMultipart sends a multi-part POST call, including an optional uploaded file,
 // and returns the response.  Primarily intended to support Ingest calls.
 func SubmitMultipart(bodyStr, address, filename, authKey string, fileData []byte) (*http.Response, error) {
+	return SubmitMultipartContext(context.Background(), bodyStr, address, filename, authKey, fileData)
+}
+
+// SubmitMultipartContext is SubmitMultipart within the trace of the given
+// context.  Ending the context aborts the call.
+func SubmitMultipartContext(ctx context.Context, bodyStr, address, filename, authKey string, fileData []byte) (*http.Response, error) {
 
 	var (
 		body   = &bytes.Buffer{}
@@ -129,7 +143,7 @@ func SubmitMultipart(bodyStr, address, filename, authKey string, fileData []byte
 		return nil, TraceErr(err)
 	}
 
-	fileReq, err := http.NewRequest("POST", address, body)
+	fileReq, err := http.NewRequestWithContext(ctx, "POST", address, body)
 	if err != nil {
 		return nil, TraceErr(err)
 	}
@@ -137,14 +151,14 @@ func SubmitMultipart(bodyStr, address, filename, authKey string, fileData []byte
 	fileReq.Header.Add("Content-Type", writer.FormDataContentType())
 	fileReq.Header.Add("Authorization", authKey)
 
-	resp, err := client.Do(fileReq)
+	resp, err := doTraced(ctx, client, fileReq, fileReq.ContentLength)
 	if err != nil {
-		return nil, TraceErr(err)
+		return nil, withCorrelation(ctx, err)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		defer resp.Body.Close()
 		errByt, _ := ioutil.ReadAll(resp.Body)
-		return resp, ErrWithTrace("Failed to POST multipart to " + address + " Status: " + resp.Status + "\n" + string(errByt))
+		return resp, withCorrelation(ctx, ErrWithTrace("Failed to POST multipart to "+address+" Status: "+resp.Status+"\n"+string(errByt)))
 	}
 	return resp, nil
 }
@@ -152,6 +166,12 @@ func SubmitMultipart(bodyStr, address, filename, authKey string, fileData []byte
 // SubmitSinglePart sends a single-part GET/POST/PUT/DELETE call to the target URL
 // and returns the result.  Includes the necessary headers.
 func SubmitSinglePart(method, bodyStr, url, authKey string) (*http.Response, error) {
+	return SubmitSinglePartContext(context.Background(), method, bodyStr, url, authKey)
+}
+
+// SubmitSinglePartContext is SubmitSinglePart within the trace of the given
+// context.  Ending the context aborts the call.
+func SubmitSinglePartContext(ctx context.Context, method, bodyStr, url, authKey string) (*http.Response, error) {
 
 	var (
 		fileReq *http.Request
@@ -164,13 +184,13 @@ func SubmitSinglePart(method, bodyStr, url, authKey string) (*http.Response, err
 	}
 
 	if bodyStr != "" {
-		fileReq, err = http.NewRequest(method, url, bytes.NewBuffer([]byte(bodyStr)))
+		fileReq, err = http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer([]byte(bodyStr)))
 		if err != nil {
 			return nil, TraceErr(err)
 		}
 		fileReq.Header.Add("Content-Type", "application/json")
 	} else {
-		fileReq, err = http.NewRequest(method, url, nil)
+		fileReq, err = http.NewRequestWithContext(ctx, method, url, nil)
 		if err != nil {
 			return nil, TraceErr(err)
 		}
@@ -178,59 +198,110 @@ func SubmitSinglePart(method, bodyStr, url, authKey string) (*http.Response, err
 
 	fileReq.Header.Add("Authorization", authKey)
 
-	resp, err := client.Do(fileReq)
+	resp, err := doTraced(ctx, client, fileReq, int64(len(bodyStr)))
 	if err != nil {
-		return nil, TraceErr(err)
+		return nil, withCorrelation(ctx, err)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		defer resp.Body.Close()
 		errByt, _ := ioutil.ReadAll(resp.Body)
-		return resp, ErrWithTrace("Failed in " + method + " call to " + url + ".  Status : " + resp.Status + "\nRequest: " + bodyStr + "\nResponse: " + string(errByt))
+		return resp, withCorrelation(ctx, ErrWithTrace("Failed in "+method+" call to "+url+".  Status : "+resp.Status+"\nRequest: "+bodyStr+"\nResponse: "+string(errByt)))
 	}
 
 	return resp, nil
 }
 
 // GetJobResponse will repeatedly poll the job status on the given job Id
-// until job completion, then acquires and returns the DataResult.
+// until job completion, then acquires and returns the DataResult.  Polling
+// is done by the default JobWatcher, which shares a budget of ten status
+// requests per second across every job in the process.  With N jobs being
+// waited on at once, each one is therefore polled only every N/10 seconds
+// or so (but never more than once a second), and its completion may be
+// noticed that much later.
 func GetJobResponse(jobID, pzAddr, authKey string) (*DataResult, error) {
+	return GetJobResponseContext(context.Background(), jobID, pzAddr, authKey)
+}
+
+// GetJobResponseContext is GetJobResponse, with the job traced within the
+// trace of the given context.  Ending the context stops the wait, but not
+// the job.
+func GetJobResponseContext(ctx context.Context, jobID, pzAddr, authKey string) (*DataResult, error) {
+	respObj, err := waitForJob(ctx, jobID, pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	return respObj.Result, nil
+}
+
+// waitForJob watches the given job through the default JobWatcher until
+// job completion or the end of the context, and returns the final status.
+// Failed jobs are errors.  As nothing else can wait on the job, it stops
+// being watched when the context ends, although it carries on in Pz.
+func waitForJob(ctx context.Context, jobID, pzAddr, authKey string) (*JobStatusResp, error) {
 
 	if jobID == "" {
 		return nil, fmt.Errorf(`JobID not provided.  Cannot acquire DataResult.`)
 	}
 
-	for i := 0; i < 300; i++ { // will wait up to 5 minutes
-
-		var outpObj struct {
-			Data JobStatusResp `json:"data,omitempty"`
-		}
-		respBuf, err := RequestKnownJSON("GET", "", pzAddr+"/job/"+jobID, authKey, &outpObj)
-		if err != nil {
-			return nil, TraceErr(err)
+	job := NewJobContext(ctx, jobID, pzAddr, authKey)
+	if _, err := job.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			job.unwatch(err)
 		}
+		return nil, err
+	}
+	return job.Status(), nil
+}
 
-		respObj := &outpObj.Data
-		if respObj.Status == "Submitted" ||
-			respObj.Status == "Running" ||
-			respObj.Status == "Pending" ||
-			(respObj.Status == "Success" && respObj.Result == nil) ||
-			(respObj.Status == "Error" && respObj.Result.Message == "Job Not Found.") {
-			time.Sleep(time.Second)
-		} else {
-			if respObj.Status == "Success" {
-				return respObj.Result, nil
-			}
-			if respObj.Status == "Fail" {
-				return nil, ErrWithTrace("Piazza failure when acquiring DataId.  Response json: " + string(respBuf))
-			}
-			if respObj.Status == "Error" {
-				return nil, ErrWithTrace("Piazza error when acquiring DataId.  Response json: " + string(respBuf))
-			}
-			return nil, ErrWithTrace(`Unknown status "` + respObj.Status + `" when acquiring DataId.  Response json: ` + string(respBuf))
-		}
+// jobFinalErr returns nil if the given final job status is a success, and
+// an error describing the failure otherwise.
+func jobFinalErr(respObj *JobStatusResp, respBuf []byte) error {
+	switch respObj.Status {
+	case "Success":
+		return nil
+	case "Fail":
+		return ErrWithTrace("Piazza failure when acquiring DataId.  Response json: " + string(respBuf))
+	case "Error":
+		return ErrWithTrace("Piazza error when acquiring DataId.  Response json: " + string(respBuf))
+	}
+	return ErrWithTrace(`Unknown status "` + respObj.Status + `" when acquiring DataId.  Response json: ` + string(respBuf))
+}
+
+// jobStillRunning returns true if the given status indicates that the job
+// has not yet reached a final state.  Pz occasionally reports success before
+// the result is available, and reports brand new jobs as not found.
+func jobStillRunning(respObj *JobStatusResp) bool {
+	return respObj.Status == "Submitted" ||
+		respObj.Status == "Running" ||
+		respObj.Status == "Pending" ||
+		(respObj.Status == "Success" && respObj.Result == nil) ||
+		(respObj.Status == "Error" && respObj.Result != nil && respObj.Result.Message == "Job Not Found.")
+}
+
+// getJobStatus polls the status of the given job once.  The raw result JSON
+// is kept on the response object, for typed decoding later.  The response
+// buffer is returned for use in error messages.
+func getJobStatus(ctx context.Context, jobID, pzAddr, authKey string) (*JobStatusResp, []byte, error) {
+	var outpObj struct {
+		Data struct {
+			JobStatusResp
+			RawResult json.RawMessage `json:"result,omitempty"`
+		} `json:"data,omitempty"`
+	}
+	respBuf, err := RequestKnownJSONContext(ctx, "GET", "", pzAddr+"/job/"+jobID, authKey, &outpObj)
+	if err != nil {
+		return nil, respBuf, TraceErr(err)
 	}
 
-	return nil, ErrWithTrace("Never completed.  JobId: " + jobID)
+	respObj := outpObj.Data.JobStatusResp
+	if raw := outpObj.Data.RawResult; len(raw) != 0 && string(raw) != "null" {
+		respObj.RawResult = raw
+		respObj.Result = &DataResult{}
+		if err = json.Unmarshal(raw, respObj.Result); err != nil {
+			return nil, respBuf, ErrWithTrace("Unmarshal of job result failed: " + err.Error() + ".  Original input: " + string(respBuf) + ".")
+		}
+	}
+	return &respObj, respBuf, nil
 }
 
 // GetJobID is a simple function to extract the job ID from
@@ -0,0 +1,309 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Job is a handle on a Pz job that is being watched in the background by a
+// JobWatcher.  It is created by NewJob or JobWatcher.Watch, or by the async
+// variants of job-creating calls (IngestAsync, DeployAsync), and is safe for
+// concurrent use.
+type Job struct {
+	id      string
+	pzAddr  string
+	authKey string
+	watcher *JobWatcher
+
+	lock   sync.Mutex
+	status *JobStatusResp
+	result JobResult
+	err    error
+
+	done    chan struct{}
+	finish  sync.Once
+	started time.Time
+	ctx     context.Context // carries the job's span, but never ends
+	span    *Span
+
+	// owned by the watcher
+	nextPoll time.Time
+	polls    int
+}
+
+// NewJob starts watching the given job through the default JobWatcher, and
+// returns a handle on it.
+func NewJob(jobID, pzAddr, authKey string) *Job {
+	return DefaultJobWatcher().Watch(jobID, pzAddr, authKey)
+}
+
+// NewJobContext is NewJob, with the job traced within the trace of the
+// given context.  Ending the context does not affect the job.
+func NewJobContext(ctx context.Context, jobID, pzAddr, authKey string) *Job {
+	return DefaultJobWatcher().WatchContext(ctx, jobID, pzAddr, authKey)
+}
+
+// ID returns the Pz job ID.
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Status returns the most recent status seen for the job, or nil if it has
+// not yet been polled.  The returned object must not be modified.
+func (j *Job) Status() *JobStatusResp {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.status
+}
+
+// Done returns a channel that is closed once the job has reached a final
+// state, failed to be polled, or been cancelled.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait blocks until the job is done or the context ends, and returns the
+// result of the job, as per DecodeJobResult.  Ending the context only stops
+// the wait - the job continues, and may be waited on again.
+func (j *Job) Wait(ctx context.Context) (JobResult, error) {
+	select {
+	case <-j.done:
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		return j.result, j.err
+	case <-ctx.Done():
+		return nil, TraceErr(ctx.Err())
+	}
+}
+
+// Cancel asks Pz to abort the job and stops watching it.  Waiters receive an
+// error.  Cancelling a job that is already done has no effect.
+func (j *Job) Cancel() error {
+	select {
+	case <-j.done:
+		return nil
+	default:
+	}
+	if err := CancelJob(j.id, "", j.pzAddr, j.authKey); err != nil {
+		return TraceErr(err)
+	}
+	j.complete(nil, ErrWithTrace("Job cancelled.  JobId: "+j.id))
+	j.watcher.wakeUp()
+	return nil
+}
+
+// unwatch completes the job with the given error and stops watching it,
+// without cancelling it in Pz.  It is for jobs nobody is left to wait on.
+func (j *Job) unwatch(err error) {
+	j.complete(nil, err)
+	j.watcher.remove(j)
+	j.watcher.wakeUp()
+}
+
+// complete records the outcome of the job and releases its waiters.  Only
+// the first call has any effect.
+func (j *Job) complete(result JobResult, err error) {
+	j.finish.Do(func() {
+		j.lock.Lock()
+		j.result, j.err = result, err
+		jobType, outcome := "unknown", "success"
+		if j.status != nil && j.status.JobType != "" {
+			jobType = j.status.JobType
+		}
+		if err != nil {
+			outcome = "error"
+		}
+		j.lock.Unlock()
+		metrics().JobWaited(jobType, outcome, time.Since(j.started))
+		j.span.SetAttr("pz.job_type", jobType)
+		j.span.Finish(err)
+		close(j.done)
+	})
+}
+
+// isDone reports whether the job has been completed.
+func (j *Job) isDone() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// poll checks the status of the job once, completing it if it has finished
+// or can no longer be polled.  Returns true if the job is done.
+func (j *Job) poll() bool {
+	ctx, cancel := context.WithTimeout(j.ctx, j.watcher.pollTimeout)
+	defer cancel()
+	respObj, respBuf, err := getJobStatus(ctx, j.id, j.pzAddr, j.authKey)
+	if err != nil {
+		j.complete(nil, TraceErr(err))
+		return true
+	}
+	j.lock.Lock()
+	j.status = respObj
+	j.lock.Unlock()
+
+	if !jobStillRunning(respObj) {
+		if err = jobFinalErr(respObj, respBuf); err != nil {
+			j.complete(nil, withCorrelation(j.ctx, err))
+			return true
+		}
+		result, err := DecodeJobResult(respObj.JobType, respObj.RawResult)
+		j.complete(result, TraceErr(err))
+		return true
+	}
+
+	j.polls++
+	if j.polls >= jobMaxPolls {
+		j.complete(nil, withCorrelation(j.ctx, ErrWithTrace("Never completed.  JobId: "+j.id)))
+		return true
+	}
+	return j.isDone()
+}
+
+// DecodeJobResult turns the raw result of a job into a typed JobResult, based
+// on the job type reported by Pz ("ingest", "access", "execute-service").
+// Error results are recognized regardless of job type.  Results of job types
+// it does not recognize are returned as UnknownResult.
+func DecodeJobResult(jobType string, raw json.RawMessage) (JobResult, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ErrWithTrace(`Job of type "` + jobType + `" has no result.`)
+	}
+
+	var res struct {
+		DataResult
+		Type string `json:"type,omitempty"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, ErrWithTrace("Unmarshal of job result failed: " + err.Error() + ".  Original input: " + string(raw) + ".")
+	}
+
+	if res.Type == "error" {
+		return ErrorResult{Message: res.Message, Details: res.Details}, nil
+	}
+	switch jobType {
+	case "ingest":
+		return IngestResult{DataID: res.DataID}, nil
+	case "access":
+		return DeploymentResult{Deployment: res.Deployment}, nil
+	case "execute-service":
+		return ExecuteResult{DataID: res.DataID, Text: res.Text}, nil
+	}
+	return UnknownResult{JobType: jobType, Raw: raw}, nil
+}
+
+// GetJobResult is GetJobResponse, except that it returns the result in typed
+// form, as per DecodeJobResult.
+func GetJobResult(jobID, pzAddr, authKey string) (JobResult, error) {
+	return GetJobResultContext(context.Background(), jobID, pzAddr, authKey)
+}
+
+// GetJobResultContext is GetJobResult, with the job traced within the trace
+// of the given context.  Ending the context stops the wait, but not the job.
+func GetJobResultContext(ctx context.Context, jobID, pzAddr, authKey string) (JobResult, error) {
+	respObj, err := waitForJob(ctx, jobID, pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	result, err := DecodeJobResult(respObj.JobType, respObj.RawResult)
+	return result, TraceErr(err)
+}
+
+// GetJobStatus polls the status of the given job once, without waiting for
+// it to complete.
+func GetJobStatus(jobID, pzAddr, authKey string) (*JobStatusResp, error) {
+	if jobID == "" {
+		return nil, ErrWithTrace("JobID not provided.  Cannot acquire job status.")
+	}
+	respObj, _, err := getJobStatus(context.Background(), jobID, pzAddr, authKey)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	return respObj, nil
+}
+
+// CancelJob asks Pz to abort the given job.  The reason is recorded by Pz
+// and may be left empty.
+func CancelJob(jobID, reason, pzAddr, authKey string) error {
+	if jobID == "" {
+		return ErrWithTrace("JobID not provided.  Cannot cancel job.")
+	}
+	query := pzAddr + "/job/" + url.PathEscape(jobID)
+	if reason != "" {
+		query += "?reason=" + url.QueryEscape(reason)
+	}
+	resp, err := SubmitSinglePart("DELETE", "", query, authKey)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return TraceErr(err)
+}
+
+// RepeatJob asks Pz to resubmit the given job, and returns the ID of the
+// new job.
+func RepeatJob(jobID, pzAddr, authKey string) (string, error) {
+	if jobID == "" {
+		return "", ErrWithTrace("JobID not provided.  Cannot repeat job.")
+	}
+	resp, err := SubmitSinglePart("PUT", "", pzAddr+"/job/"+url.PathEscape(jobID), authKey)
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return "", TraceErr(err)
+	}
+	defer resp.Body.Close()
+	newID, err := GetJobID(resp)
+	return newID, TraceErr(err)
+}
+
+// ListJobs retrieves the jobs matching the given user name, status and job
+// type, under the given pagination.  Any of these may be left empty.
+func ListJobs(userName, status, jobType, perPage, pageNo, pzAddr, authKey string) (*JobStatusList, error) {
+
+	params := url.Values{}
+	if userName != "" {
+		params.Set("userName", userName)
+	}
+	if status != "" {
+		params.Set("status", status)
+	}
+	if jobType != "" {
+		params.Set("jobType", jobType)
+	}
+	if perPage != "" {
+		params.Set("perPage", perPage)
+	}
+	if pageNo != "" {
+		params.Set("page", pageNo)
+	}
+	query := pzAddr + "/job"
+	if len(params) != 0 {
+		query += "?" + params.Encode()
+	}
+
+	var outpObj JobStatusList
+	if _, err := RequestKnownJSON("GET", "", query, authKey, &outpObj); err != nil {
+		return nil, TraceErr(err)
+	}
+	return &outpObj, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDecodeJobResult(t *testing.T) {
+	cases := []struct {
+		jobType string
+		raw     string
+		check   func(JobResult) bool
+	}{
+		{"ingest", `{"type":"data", "dataId":"d1"}`, func(res JobResult) bool {
+			ing, ok := res.(IngestResult)
+			return ok && ing.DataID == "d1"
+		}},
+		{"access", `{"type":"deployment", "deployment":{"deploymentId":"dp1"}}`, func(res JobResult) bool {
+			depl, ok := res.(DeploymentResult)
+			return ok && depl.Deployment.DeplID == "dp1"
+		}},
+		{"execute-service", `{"type":"text", "text":"hello"}`, func(res JobResult) bool {
+			exec, ok := res.(ExecuteResult)
+			return ok && exec.IsText() && exec.Text == "hello"
+		}},
+		{"ingest", `{"type":"error", "message":"broken", "details":"badly"}`, func(res JobResult) bool {
+			errRes, ok := res.(ErrorResult)
+			return ok && errRes.Error() == "broken: badly"
+		}},
+		{"search-query", `{"type":"something", "stuff":[1,2]}`, func(res JobResult) bool {
+			unk, ok := res.(UnknownResult)
+			return ok && string(unk.Raw) == `{"type":"something", "stuff":[1,2]}`
+		}},
+	}
+	for i, testCase := range cases {
+		res, err := DecodeJobResult(testCase.jobType, json.RawMessage(testCase.raw))
+		if err != nil || !testCase.check(res) {
+			t.Errorf(`TestDecodeJobResult: case %d decoded incorrectly: %#v, %v`, i, res, err)
+		}
+	}
+	if _, err := DecodeJobResult("ingest", nil); err == nil {
+		t.Error(`TestDecodeJobResult: passed on missing result.`)
+	}
+}
+
+func TestGetJobResult(t *testing.T) {
+	outStrs := []string{`{"data":{"status":"Success", "jobType":"ingest", "result":{"type":"data", "dataId":"d1"}}}`}
+	SetMockClient(outStrs, 200)
+
+	res, err := GetJobResult("testJobID", "http://testURL.net", "testAuthKey")
+	if ing, ok := res.(IngestResult); err != nil || !ok || ing.DataID != "d1" {
+		t.Errorf(`TestGetJobResult: bad result: %#v, %v`, res, err)
+	}
+}
+
+func TestJobManagement(t *testing.T) {
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+	outStrs := []string{
+		`{"data":{"jobId":"job1", "status":"Running"}}`,
+		`{}`,
+		`{"data":{"jobId":"job2"}}`,
+		`{"type":"job-list", "data":[{"jobId":"job1", "status":"Error"}, {"jobId":"job3", "status":"Error"}], "pagination":{"count":2}}`}
+	SetMockClient(outStrs, 200)
+
+	status, err := GetJobStatus("job1", url, authKey)
+	if err != nil || status.Status != "Running" {
+		t.Errorf(`TestJobManagement: bad status: %#v, %v`, status, err)
+	}
+	if err = CancelJob("job1", "runaway", url, authKey); err != nil {
+		t.Error(`TestJobManagement: cancel failed: ` + err.Error())
+	}
+	newID, err := RepeatJob("job1", url, authKey)
+	if err != nil || newID != "job2" {
+		t.Errorf(`TestJobManagement: bad repeat: "%s", %v`, newID, err)
+	}
+	list, err := ListJobs("me", "Error", "", "10", "0", url, authKey)
+	if err != nil || len(list.Data) != 2 || list.Pagination.Count != 2 {
+		t.Errorf(`TestJobManagement: bad list: %#v, %v`, list, err)
+	}
+	if _, err = GetJobStatus("", url, authKey); err == nil {
+		t.Error(`TestJobManagement: passed on empty job ID.`)
+	}
+}
+
+func TestJob(t *testing.T) {
+	SetDefaultJobWatcher(NewJobWatcher(0, time.Millisecond))
+	defer SetDefaultJobWatcher(nil)
+	url := "http://testURL.net"
+	authKey := "testAuthKey"
+
+	outStrs := []string{
+		`{"data":{"jobId":"job1"}}`,
+		`{"data":{"status":"Running"}}`,
+		`{"data":{"status":"Success", "jobType":"ingest", "result":{"type":"data", "dataId":"d1"}}}`}
+	SetMockClient(outStrs, 200)
+
+	job, err := IngestAsync("a.txt", "text", url, "tester", "1.0", authKey, DefaultClassType(), []byte("hi"), nil)
+	if err != nil {
+		t.Fatal(`TestJob: IngestAsync failed: ` + err.Error())
+	}
+	if job.ID() != "job1" {
+		t.Error(`TestJob: bad job ID: "` + job.ID() + `"`)
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal(`TestJob: job never finished.`)
+	}
+	res, err := job.Wait(context.Background())
+	if ing, ok := res.(IngestResult); err != nil || !ok || ing.DataID != "d1" {
+		t.Errorf(`TestJob: bad result: %#v, %v`, res, err)
+	}
+	if status := job.Status(); status == nil || status.Status != "Success" {
+		t.Errorf(`TestJob: bad final status: %#v`, status)
+	}
+
+	SetMockClient([]string{`{"data":{"status":"Fail"}}`}, 200)
+	if _, err = NewJob("job2", url, authKey).Wait(context.Background()); err == nil {
+		t.Error(`TestJob: passed on failed job.`)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	SetDefaultJobWatcher(NewJobWatcher(0, time.Hour))
+	defer SetDefaultJobWatcher(nil)
+
+	SetMockClient([]string{`{"data":{"status":"Running"}}`}, 200)
+	job := NewJob("job1", "http://testURL.net", "testAuthKey")
+	for job.Status() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := job.Wait(ctx); err == nil {
+		t.Error(`TestJobCancel: Wait did not honor context.`)
+	}
+
+	if err := job.Cancel(); err != nil {
+		t.Error(`TestJobCancel: Cancel failed: ` + err.Error())
+	}
+	<-job.Done()
+	if _, err := job.Wait(context.Background()); err == nil {
+		t.Error(`TestJobCancel: Wait passed on cancelled job.`)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+A JobWatcher polls the status of any number of Pz jobs from a single
+goroutine, so that a service with many outstanding jobs does not have each
+of them hammering the gateway independently.  Each job is polled no more
+often than the watcher's interval, and the watcher as a whole makes no more
+than its rate budget of status requests per second.  When there are more
+jobs than the budget allows for, each job is simply polled less often, in
+round-robin order.  Each status request has its own deadline, so that one
+that hangs cannot hold up every other job.  The goroutine exits whenever
+there is nothing left to watch, and is restarted on demand.
+*/
+
+// jobPollInterval, jobPollRate, jobMaxPolls and jobPollTimeout are the
+// defaults: each job is polled once a second (as with GetJobResponse), up
+// to ten status requests are made per second in total, a job is given up
+// on after 300 polls, and a status request that takes longer than ten
+// seconds fails the job.
+var (
+	jobPollInterval = time.Second
+	jobPollRate     = 10.0
+	jobMaxPolls     = 300
+	jobPollTimeout  = 10 * time.Second
+)
+
+var (
+	defaultWatcher *JobWatcher
+	watcherLock    sync.RWMutex
+)
+
+// JobWatcher multiplexes the status polling of many Pz jobs under a global
+// request-rate budget, and fans their completion out to per-job waiters.
+type JobWatcher struct {
+	interval    time.Duration
+	gap         time.Duration
+	pollTimeout time.Duration
+
+	lock    sync.Mutex
+	jobs    []*Job
+	running bool
+	wake    chan struct{}
+}
+
+// NewJobWatcher creates a JobWatcher that polls each job no more than once
+// per interval, and makes no more than maxRate status requests per second
+// across all jobs.  A maxRate of zero or less means no global limit.
+func NewJobWatcher(maxRate float64, interval time.Duration) *JobWatcher {
+	var gap time.Duration
+	if maxRate > 0 {
+		gap = time.Duration(float64(time.Second) / maxRate)
+	}
+	return &JobWatcher{interval: interval, gap: gap, pollTimeout: jobPollTimeout, wake: make(chan struct{}, 1)}
+}
+
+// DefaultJobWatcher returns the JobWatcher used by NewJob and
+// GetJobResponse, creating it on first use.
+func DefaultJobWatcher() *JobWatcher {
+	watcherLock.RLock()
+	w := defaultWatcher
+	watcherLock.RUnlock()
+	if w != nil {
+		return w
+	}
+
+	watcherLock.Lock()
+	defer watcherLock.Unlock()
+	if defaultWatcher == nil {
+		defaultWatcher = NewJobWatcher(jobPollRate, jobPollInterval)
+	}
+	return defaultWatcher
+}
+
+// SetDefaultJobWatcher replaces the JobWatcher used by NewJob and
+// GetJobResponse.  Jobs already being watched are unaffected.
+func SetDefaultJobWatcher(w *JobWatcher) {
+	watcherLock.Lock()
+	defer watcherLock.Unlock()
+	defaultWatcher = w
+}
+
+// Watch starts watching the given job, and returns a handle on it.
+func (w *JobWatcher) Watch(jobID, pzAddr, authKey string) *Job {
+	return w.WatchContext(context.Background(), jobID, pzAddr, authKey)
+}
+
+// WatchContext is Watch, with the job traced within the trace of the given
+// context.  The job's span lasts until it is done, and its status polls are
+// made within it.  Ending the context does not affect the job.
+func (w *JobWatcher) WatchContext(ctx context.Context, jobID, pzAddr, authKey string) *Job {
+	// the job outlives the context, so only its trace and request ID are kept
+	parent := context.Background()
+	if reqID := RequestID(ctx); reqID != "" {
+		parent = context.WithValue(parent, requestIDKey{}, reqID)
+	}
+	if tc, ok := TraceFromContext(ctx); ok {
+		if untraced, _ := ctx.Value(untracedKey{}).(bool); untraced {
+			parent = context.WithValue(parent, untracedKey{}, true)
+		}
+		parent = context.WithValue(parent, traceKey{}, tc)
+	}
+	jobCtx, span := startSpan(parent, "pz.job")
+	span.SetAttr("pz.job_id", jobID)
+	job := &Job{
+		id:      jobID,
+		pzAddr:  pzAddr,
+		authKey: authKey,
+		watcher: w,
+		done:    make(chan struct{}),
+		started: time.Now(),
+		ctx:     jobCtx,
+		span:    span}
+	if jobID == "" {
+		job.complete(nil, ErrWithTrace("JobID not provided.  Cannot acquire job status."))
+		return job
+	}
+
+	w.lock.Lock()
+	job.nextPoll = time.Now()
+	w.jobs = append(w.jobs, job)
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	w.lock.Unlock()
+	w.wakeUp()
+	return job
+}
+
+// Watching returns the number of jobs currently being watched.
+func (w *JobWatcher) Watching() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.jobs)
+}
+
+// wakeUp prompts the watcher to reconsider its schedule, without blocking.
+func (w *JobWatcher) wakeUp() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove drops the given job from the watch list.
+func (w *JobWatcher) remove(job *Job) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i, watched := range w.jobs {
+		if watched == job {
+			last := len(w.jobs) - 1
+			copy(w.jobs[i:], w.jobs[i+1:])
+			w.jobs[last] = nil
+			w.jobs = w.jobs[:last]
+			return
+		}
+	}
+}
+
+// next drops any finished jobs from the watch list and returns the one
+// that is due to be polled soonest, or nil if there are none.  The caller
+// must hold the lock.
+func (w *JobWatcher) next() *Job {
+	var (
+		due  *Job
+		kept = w.jobs[:0]
+	)
+	for _, job := range w.jobs {
+		if job.isDone() {
+			continue
+		}
+		kept = append(kept, job)
+		if due == nil || job.nextPoll.Before(due.nextPoll) {
+			due = job
+		}
+	}
+	for i := len(kept); i < len(w.jobs); i++ {
+		w.jobs[i] = nil
+	}
+	w.jobs = kept
+	return due
+}
+
+// run is the polling loop.  It exits once there are no jobs left.
+func (w *JobWatcher) run() {
+	var lastPoll time.Time
+	for {
+		w.lock.Lock()
+		job := w.next()
+		if job == nil {
+			w.running = false
+			w.lock.Unlock()
+			return
+		}
+		dueAt := job.nextPoll
+		if budgetAt := lastPoll.Add(w.gap); budgetAt.After(dueAt) {
+			dueAt = budgetAt
+		}
+		w.lock.Unlock()
+
+		if wait := time.Until(dueAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.wake:
+				timer.Stop()
+			}
+			continue
+		}
+
+		lastPoll = time.Now()
+		finished := job.poll()
+
+		w.lock.Lock()
+		if !finished {
+			job.nextPoll = time.Now().Add(w.interval)
+		}
+		w.lock.Unlock()
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jobStatusTransport answers job status requests by job ID, reporting each
+// job as running for its first runFor polls and successful after that.
+// Requests for the hang job ID get no answer until they are cancelled.
+type jobStatusTransport struct {
+	runFor   int
+	hang     string
+	lock     sync.Mutex
+	polls    map[string]int
+	inFlight int
+	maxIn    int
+}
+
+func (t *jobStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jobID := path.Base(req.URL.Path)
+	t.lock.Lock()
+	t.polls[jobID]++
+	count := t.polls[jobID]
+	t.inFlight++
+	if t.inFlight > t.maxIn {
+		t.maxIn = t.inFlight
+	}
+	t.lock.Unlock()
+
+	if jobID == t.hang && t.hang != "" {
+		<-req.Context().Done()
+		t.lock.Lock()
+		t.inFlight--
+		t.lock.Unlock()
+		return nil, req.Context().Err()
+	}
+
+	time.Sleep(time.Millisecond)
+	body := `{"data":{"status":"Running"}}`
+	if count > t.runFor {
+		body = `{"data":{"status":"Success", "jobType":"ingest", "result":{"type":"data", "dataId":"` + jobID + `"}}}`
+	}
+
+	t.lock.Lock()
+	t.inFlight--
+	t.lock.Unlock()
+	return &http.Response{StatusCode: 200, Header: make(http.Header), Request: req, Body: GetMockReadCloser(body)}, nil
+}
+
+func TestJobWatcher(t *testing.T) {
+	trans := &jobStatusTransport{runFor: 2, polls: make(map[string]int)}
+	SetHTTPClient(&http.Client{Transport: trans})
+	defer SetHTTPClient(nil)
+
+	watcher := NewJobWatcher(0, time.Millisecond)
+	var jobs []*Job
+	for i := 0; i < 20; i++ {
+		jobs = append(jobs, watcher.Watch("job"+strconv.Itoa(i), "http://testURL.net", "testAuthKey"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, job := range jobs {
+		res, err := job.Wait(ctx)
+		if ing, ok := res.(IngestResult); err != nil || !ok || ing.DataID != job.ID() {
+			t.Errorf(`TestJobWatcher: bad result for %s: %#v, %v`, job.ID(), res, err)
+		}
+	}
+
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	if trans.maxIn != 1 {
+		t.Errorf(`TestJobWatcher: %d concurrent status requests, expected 1.`, trans.maxIn)
+	}
+	for _, job := range jobs {
+		if trans.polls[job.ID()] != 3 {
+			t.Errorf(`TestJobWatcher: %s polled %d times, expected 3.`, job.ID(), trans.polls[job.ID()])
+		}
+	}
+	if watcher.Watching() != 0 {
+		t.Errorf(`TestJobWatcher: still watching %d jobs.`, watcher.Watching())
+	}
+}
+
+func TestJobWatcherRate(t *testing.T) {
+	trans := &jobStatusTransport{runFor: 0, polls: make(map[string]int)}
+	SetHTTPClient(&http.Client{Transport: trans})
+	defer SetHTTPClient(nil)
+
+	watcher := NewJobWatcher(100, time.Millisecond)
+	start := time.Now()
+	var jobs []*Job
+	for i := 0; i < 6; i++ {
+		jobs = append(jobs, watcher.Watch("job"+strconv.Itoa(i), "http://testURL.net", "testAuthKey"))
+	}
+	for _, job := range jobs {
+		<-job.Done()
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf(`TestJobWatcherRate: 6 polls at 100/s took only %v.`, elapsed)
+	}
+
+	if job := watcher.Watch("", "http://testURL.net", "testAuthKey"); !job.isDone() {
+		t.Error(`TestJobWatcherRate: job with no ID was watched.`)
+	}
+}
+
+func TestJobWatcherHang(t *testing.T) {
+	trans := &jobStatusTransport{runFor: 1, hang: "stuck", polls: make(map[string]int)}
+	SetHTTPClient(&http.Client{Transport: trans})
+	defer SetHTTPClient(nil)
+
+	watcher := NewJobWatcher(0, time.Millisecond)
+	watcher.pollTimeout = 50 * time.Millisecond
+	stuck := watcher.Watch("stuck", "http://testURL.net", "testAuthKey")
+	fine := watcher.Watch("fine", "http://testURL.net", "testAuthKey")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := fine.Wait(ctx); err != nil {
+		t.Error(`TestJobWatcherHang: job held up by a hung poll: ` + err.Error())
+	}
+	if _, err := stuck.Wait(ctx); err == nil || ctx.Err() != nil {
+		t.Errorf(`TestJobWatcherHang: hung poll did not fail its job in time: %v`, err)
+	}
+}
+
+func TestJobWatcherAbandon(t *testing.T) {
+	trans := &jobStatusTransport{runFor: jobMaxPolls, polls: make(map[string]int)}
+	SetHTTPClient(&http.Client{Transport: trans})
+	defer SetHTTPClient(nil)
+	watcher := NewJobWatcher(0, time.Millisecond)
+	SetDefaultJobWatcher(watcher)
+	defer SetDefaultJobWatcher(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := GetJobResponseContext(ctx, "abandoned", "http://testURL.net", "testAuthKey"); err == nil {
+		t.Fatal(`TestJobWatcherAbandon: wait outlived its context.`)
+	}
+	if watching := watcher.Watching(); watching != 0 {
+		t.Errorf(`TestJobWatcherAbandon: still watching %d jobs.`, watching)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	trans.lock.Lock()
+	before := trans.polls["abandoned"]
+	trans.lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	trans.lock.Lock()
+	after := trans.polls["abandoned"]
+	trans.lock.Unlock()
+	if after != before {
+		t.Errorf(`TestJobWatcherAbandon: abandoned job still polled; %d polls became %d.`, before, after)
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+The library reports what it is doing through a MetricsHook: every call made
+through SubmitSinglePart and SubmitMultipart, every retry made by an
+AuthTransport, the bytes sent and received, how long each Job took, and how
+long callers waited on Semaphores.  By default these go nowhere.
+PromMetrics is a MetricsHook that keeps them in memory and serves them in
+the Prometheus text exposition format.
+*/
+
+// MetricsHook receives measurements from the library.  Implementations
+// must be safe for concurrent use, and should be quick.
+type MetricsHook interface {
+	// RequestDone records a completed call to Pz.  The endpoint is the
+	// request path with IDs replaced by "{id}".  The status is zero if no
+	// response was received.
+	RequestDone(method, endpoint string, status int, duration time.Duration)
+	// RequestRetried records a call that was retried.
+	RequestRetried(method, endpoint string)
+	// BytesTransferred records bytes sent ("upload") or received
+	// ("download").
+	BytesTransferred(direction string, n int64)
+	// JobWaited records how long a Job took from submission to completion,
+	// by job type and outcome ("success" or "error").
+	JobWaited(jobType, outcome string, duration time.Duration)
+	// SemaphoreWaited records how long a Semaphore claim waited, and
+	// whether it was granted.
+	SemaphoreWaited(duration time.Duration, acquired bool)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) RequestDone(string, string, int, time.Duration) {}
+func (nopMetrics) RequestRetried(string, string)                  {}
+func (nopMetrics) BytesTransferred(string, int64)                 {}
+func (nopMetrics) JobWaited(string, string, time.Duration)        {}
+func (nopMetrics) SemaphoreWaited(time.Duration, bool)            {}
+
+var (
+	metricsHook MetricsHook = nopMetrics{}
+	metricsLock sync.RWMutex
+)
+
+// SetMetricsHook sets the MetricsHook that the library reports to.  Nil
+// turns reporting off.
+func SetMetricsHook(hook MetricsHook) {
+	if hook == nil {
+		hook = nopMetrics{}
+	}
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metricsHook = hook
+}
+
+func metrics() MetricsHook {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	return metricsHook
+}
+
+// metricEndpoint reduces a URL to its path, with any segment containing a
+// digit (job IDs, data IDs and so forth) replaced with "{id}", to keep the
+// number of distinct endpoints down.
+func metricEndpoint(address string) string {
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Path == "" {
+		return "/"
+	}
+	segments := strings.Split(parsed.Path, "/")
+	for i, seg := range segments {
+		if strings.IndexFunc(seg, unicode.IsDigit) >= 0 {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// meteredBody counts the bytes read from a response body, and reports them
+// when the body is closed.
+type meteredBody struct {
+	io.ReadCloser
+	count int64
+	once  sync.Once
+}
+
+func (mb *meteredBody) Read(p []byte) (int, error) {
+	n, err := mb.ReadCloser.Read(p)
+	mb.count += int64(n)
+	return n, err
+}
+
+func (mb *meteredBody) Close() error {
+	mb.once.Do(func() { metrics().BytesTransferred("download", mb.count) })
+	return mb.ReadCloser.Close()
+}
+
+// doMetered sends the request through the client, reporting it to the
+// MetricsHook.
+func doMetered(client *http.Client, req *http.Request, upload int64) (*http.Response, error) {
+	hook := metrics()
+	start := time.Now()
+	resp, err := client.Do(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+		resp.Body = &meteredBody{ReadCloser: resp.Body}
+	}
+	hook.RequestDone(req.Method, metricEndpoint(req.URL.String()), status, time.Since(start))
+	hook.BytesTransferred("upload", upload)
+	return resp, err
+}
+
+// DefaultBuckets are the histogram buckets, in seconds, used by PromMetrics.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type promSeries struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type promFamily struct {
+	name       string
+	help       string
+	histogram  bool
+	labelNames []string
+	series     map[string]*promSeries
+}
+
+// PromMetrics is a MetricsHook that accumulates measurements in memory and
+// serves them, as an http.Handler, in the Prometheus text format.
+type PromMetrics struct {
+	buckets  []float64
+	lock     sync.Mutex
+	families map[string]*promFamily
+}
+
+// NewPromMetrics creates an empty PromMetrics.
+func NewPromMetrics() *PromMetrics {
+	pm := &PromMetrics{buckets: DefaultBuckets, families: make(map[string]*promFamily)}
+	pm.family("pzsvc_requests_total", "Calls made to Pz, by method, endpoint and status.", false, "method", "endpoint", "status")
+	pm.family("pzsvc_request_duration_seconds", "Duration of calls made to Pz.", true, "method", "endpoint")
+	pm.family("pzsvc_request_retries_total", "Calls to Pz that were retried.", false, "method", "endpoint")
+	pm.family("pzsvc_bytes_total", "Bytes sent to and received from Pz.", false, "direction")
+	pm.family("pzsvc_job_wait_seconds", "Time from job submission to completion.", true, "job_type", "outcome")
+	pm.family("pzsvc_semaphore_wait_seconds", "Time spent waiting on semaphores.", true, "acquired")
+	return pm
+}
+
+func (pm *PromMetrics) family(name, help string, histogram bool, labelNames ...string) {
+	pm.families[name] = &promFamily{name, help, histogram, labelNames, make(map[string]*promSeries)}
+}
+
+func (pm *PromMetrics) series(name string, labels ...string) *promSeries {
+	fam := pm.families[name]
+	key := strings.Join(labels, "\xff")
+	ser := fam.series[key]
+	if ser == nil {
+		ser = &promSeries{labels: labels}
+		if fam.histogram {
+			ser.buckets = make([]uint64, len(pm.buckets))
+		}
+		fam.series[key] = ser
+	}
+	return ser
+}
+
+func (pm *PromMetrics) add(name string, val float64, labels ...string) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	pm.series(name, labels...).value += val
+}
+
+func (pm *PromMetrics) observe(name string, seconds float64, labels ...string) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	ser := pm.series(name, labels...)
+	for i, bound := range pm.buckets {
+		if seconds <= bound {
+			ser.buckets[i]++
+		}
+	}
+	ser.sum += seconds
+	ser.count++
+}
+
+// RequestDone implements MetricsHook.
+func (pm *PromMetrics) RequestDone(method, endpoint string, status int, duration time.Duration) {
+	statusStr := strconv.Itoa(status)
+	if status == 0 {
+		statusStr = "error"
+	}
+	pm.add("pzsvc_requests_total", 1, method, endpoint, statusStr)
+	pm.observe("pzsvc_request_duration_seconds", duration.Seconds(), method, endpoint)
+}
+
+// RequestRetried implements MetricsHook.
+func (pm *PromMetrics) RequestRetried(method, endpoint string) {
+	pm.add("pzsvc_request_retries_total", 1, method, endpoint)
+}
+
+// BytesTransferred implements MetricsHook.
+func (pm *PromMetrics) BytesTransferred(direction string, n int64) {
+	pm.add("pzsvc_bytes_total", float64(n), direction)
+}
+
+// JobWaited implements MetricsHook.
+func (pm *PromMetrics) JobWaited(jobType, outcome string, duration time.Duration) {
+	pm.observe("pzsvc_job_wait_seconds", duration.Seconds(), jobType, outcome)
+}
+
+// SemaphoreWaited implements MetricsHook.
+func (pm *PromMetrics) SemaphoreWaited(duration time.Duration, acquired bool) {
+	pm.observe("pzsvc_semaphore_wait_seconds", duration.Seconds(), strconv.FormatBool(acquired))
+}
+
+// ServeHTTP writes out all measurements in the Prometheus text format.
+func (pm *PromMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	HTTPOut(w, pm.Text(), http.StatusOK)
+}
+
+// Text returns all measurements in the Prometheus text format.
+func (pm *PromMetrics) Text() string {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	names := make([]string, 0, len(pm.families))
+	for name := range pm.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fam := pm.families[name]
+		typ := "counter"
+		if fam.histogram {
+			typ = "histogram"
+		}
+		buf.WriteString("# HELP " + name + " " + fam.help + "\n")
+		buf.WriteString("# TYPE " + name + " " + typ + "\n")
+
+		keys := make([]string, 0, len(fam.series))
+		for key := range fam.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ser := fam.series[key]
+			labels := promLabels(fam.labelNames, ser.labels)
+			if !fam.histogram {
+				buf.WriteString(name + promBraces(labels) + " " + promFloat(ser.value) + "\n")
+				continue
+			}
+			for i, bound := range pm.buckets {
+				le := append(labels[:len(labels):len(labels)], `le="`+promFloat(bound)+`"`)
+				buf.WriteString(name + "_bucket" + promBraces(le) + " " + strconv.FormatUint(ser.buckets[i], 10) + "\n")
+			}
+			le := append(labels[:len(labels):len(labels)], `le="+Inf"`)
+			buf.WriteString(name + "_bucket" + promBraces(le) + " " + strconv.FormatUint(ser.count, 10) + "\n")
+			buf.WriteString(name + "_sum" + promBraces(labels) + " " + promFloat(ser.sum) + "\n")
+			buf.WriteString(name + "_count" + promBraces(labels) + " " + strconv.FormatUint(ser.count, 10) + "\n")
+		}
+	}
+	return buf.String()
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabels(names, values []string) []string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + promEscaper.Replace(values[i]) + `"`
+	}
+	return pairs
+}
+
+func promBraces(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func promFloat(val float64) string {
+	if math.IsInf(val, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
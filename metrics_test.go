@@ -0,0 +1,92 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"http://pz.net/job/1a2b-3c4d":           "/job/{id}",
+		"http://pz.net/data/file":               "/data/file",
+		"http://pz.net/deployment?perPage=10":   "/deployment",
+		"http://pz.net/file/abc123/extra?x=y":   "/file/{id}/extra",
+		"http://pz.net":                         "/",
+		"http://pz.net/service/me?userName=bob": "/service/me",
+	}
+	for in, expected := range cases {
+		if out := metricEndpoint(in); out != expected {
+			t.Errorf(`TestMetricEndpoint: "%s" gave "%s", expected "%s"`, in, out, expected)
+		}
+	}
+}
+
+func TestPromMetrics(t *testing.T) {
+	pm := NewPromMetrics()
+	SetMetricsHook(pm)
+	defer SetMetricsHook(nil)
+	SetDefaultJobWatcher(NewJobWatcher(0, time.Millisecond))
+	defer SetDefaultJobWatcher(nil)
+
+	jobResp := `{"data":{"status":"Success", "jobType":"ingest", "result":{"type":"data", "dataId":"d1"}}}`
+	SetMockClient([]string{`{"a":1}`, jobResp}, 200)
+	var outObj map[string]int
+	if _, err := RequestKnownJSON("POST", `{"in":true}`, "http://testURL.net/data/1234", "testAuthKey", &outObj); err != nil {
+		t.Fatal(`TestPromMetrics: request failed: ` + err.Error())
+	}
+	if _, err := NewJob("job1", "http://testURL.net", "testAuthKey").Wait(context.Background()); err != nil {
+		t.Fatal(`TestPromMetrics: job failed: ` + err.Error())
+	}
+
+	sem := NewSemaphore(1)
+	sem.Lock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sem.Unlock()
+	}()
+	sem.Lock()
+	sem.Unlock()
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	text := rec.Body.String()
+	expected := []string{
+		"# TYPE pzsvc_requests_total counter",
+		`pzsvc_requests_total{method="POST",endpoint="/data/{id}",status="200"} 1`,
+		`pzsvc_requests_total{method="GET",endpoint="/job/{id}",status="200"} 1`,
+		"# TYPE pzsvc_request_duration_seconds histogram",
+		`pzsvc_request_duration_seconds_bucket{method="POST",endpoint="/data/{id}",le="+Inf"} 1`,
+		`pzsvc_request_duration_seconds_count{method="POST",endpoint="/data/{id}"} 1`,
+		`pzsvc_bytes_total{direction="upload"} 11`,
+		`pzsvc_bytes_total{direction="download"} ` + strconv.Itoa(7+len(jobResp)),
+		`pzsvc_job_wait_seconds_count{job_type="ingest",outcome="success"} 1`,
+		`pzsvc_semaphore_wait_seconds_count{acquired="true"} 2`,
+		`pzsvc_semaphore_wait_seconds_bucket{acquired="true",le="0.005"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf(`TestPromMetrics: output missing "%s"`, line)
+		}
+	}
+	if t.Failed() {
+		t.Log(text)
+	}
+}
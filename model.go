@@ -14,7 +14,10 @@
 
 package pzsvc
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // DataDesc is the identifying information for a specific uploaded file
 // or data block.  It is an important part of ingest requests, and the
@@ -83,6 +86,14 @@ type DeplStrct struct {
 	Port            string `json:"port,omitempty"`
 }
 
+// DeplGroup is the Pz representation of a deployment group.  Deployment
+// groups correspond to GeoServer layer groups.
+type DeplGroup struct {
+	DeplGroupID       string `json:"deploymentGroupId,omitempty"`
+	CreatedBy         string `json:"createdBy,omitempty"`
+	HasGeoServerLayer bool   `json:"hasGisServerLayer,omitempty"`
+}
+
 // DataResult is a hack to handle the fact that the backend we're addressing
 // uses a lot of inheritence here.  Any one of five different classes could
 // fill the slots set aside for DataResult objects in a job response.  Impl01
@@ -98,6 +109,70 @@ type DataResult struct {
 	Text       string    `json:"text,omitempty"`       // Impl05
 }
 
+// JobResult is the typed form of a job result, as returned by DecodeJobResult
+// and GetJobResult.  Its concrete type is one of IngestResult,
+// DeploymentResult, ExecuteResult, ErrorResult or UnknownResult, and is best
+// examined with a type switch.
+type JobResult interface {
+	ResultType() string
+}
+
+// IngestResult is the result of an ingest job.
+type IngestResult struct {
+	DataID string
+}
+
+// DeploymentResult is the result of a deployment ("access") job.
+type DeploymentResult struct {
+	Deployment DeplStrct
+}
+
+// ExecuteResult is the result of an execute-service job.  Services produce
+// either text, in which case Text is set, or data, in which case DataID
+// identifies the output resource.
+type ExecuteResult struct {
+	DataID string
+	Text   string
+}
+
+// ErrorResult is the result of a job that went wrong.
+type ErrorResult struct {
+	Message string
+	Details string
+}
+
+// UnknownResult is the result of a job type that DecodeJobResult does not
+// know about.  Raw holds the result JSON exactly as Pz sent it.
+type UnknownResult struct {
+	JobType string
+	Raw     json.RawMessage
+}
+
+// ResultType returns "ingest".
+func (IngestResult) ResultType() string { return "ingest" }
+
+// ResultType returns "deployment".
+func (DeploymentResult) ResultType() string { return "deployment" }
+
+// ResultType returns "execute".
+func (ExecuteResult) ResultType() string { return "execute" }
+
+// ResultType returns "error".
+func (ErrorResult) ResultType() string { return "error" }
+
+// ResultType returns "unknown".
+func (UnknownResult) ResultType() string { return "unknown" }
+
+// IsText returns true if the service produced text rather than data.
+func (res ExecuteResult) IsText() bool { return res.DataID == "" }
+
+func (res ErrorResult) Error() string {
+	if res.Details != "" {
+		return res.Message + ": " + res.Details
+	}
+	return res.Message
+}
+
 // JobProg is Pz's way of indicating job progress
 type JobProg struct {
 	PercentComplete int    `json:"percentComplete,omitempty"`
@@ -156,6 +231,19 @@ type IngestReq struct {
 	Type string   `json:"type,omitempty"` // "ingest"
 }
 
+// DeplReq is the request object for deployment ("access") jobs.  DeplType
+// is "geoserver" to publish the data as a GeoServer layer, or "file" to make
+// it available through the Pz file access API.  Style and LayerName only
+// apply to GeoServer deployments.
+type DeplReq struct {
+	DataID      string `json:"dataId"`
+	DeplGroupID string `json:"deploymentGroupId,omitempty"`
+	DeplType    string `json:"deploymentType,omitempty"`
+	Type        string `json:"type,omitempty"` // "access"
+	Style       string `json:"style,omitempty"`
+	LayerName   string `json:"layerName,omitempty"`
+}
+
 // ResMeta holds a resource metadata. It's used broadly
 // Worth noting that Pz pays no attention to the contents of the
 // Metadata map field except to act as a passthrough.  Among
@@ -213,6 +301,18 @@ type JobStatusResp struct {
 	Progress  JobProg     `json:"progress,omitempty"`
 	Result    *DataResult `json:"result,omitempty"`
 	Status    string      `json:"status,omitempty"`
+
+	// RawResult is the result exactly as Pz sent it, for use with
+	// DecodeJobResult.  It is not part of the Pz object.
+	RawResult json.RawMessage `json:"-"`
+}
+
+// JobStatusList is the representation of a list of job status objects.
+// It is the response object for a list jobs call.
+type JobStatusList struct {
+	Type       string          `json:"type,omitempty"`
+	Data       []JobStatusResp `json:"data,omitempty"`
+	Pagination PagStruct       `json:"pagination,omitempty"`
 }
 
 // JobInitResp is the immediate response object to all of the
@@ -279,6 +379,29 @@ type ServiceResponse struct {
 	Data Service `json:"data"`
 }
 
+// DeplResp is the response object for a Get Deployment call.
+type DeplResp struct {
+	Type string `json:"type,omitempty"`
+	Data struct {
+		Deployment DeplStrct `json:"deployment,omitempty"`
+		ExpiresOn  string    `json:"expiresOn,omitempty"`
+	} `json:"data,omitempty"`
+}
+
+// DeplList is the representation of a list of deployment objects.
+type DeplList struct {
+	Type       string      `json:"type,omitempty"`
+	Data       []DeplStrct `json:"data,omitempty"`
+	Pagination PagStruct   `json:"pagination,omitempty"`
+}
+
+// DeplGroupResp is the response object for creating or retrieving a
+// deployment group.
+type DeplGroupResp struct {
+	Type string    `json:"type,omitempty"`
+	Data DeplGroup `json:"data,omitempty"`
+}
+
 // EventTypeResponse is solely the response for adding a new EventType
 type EventTypeResponse struct {
 	Type string    `json:"type,omitempty"`
@@ -327,3 +450,10 @@ type FileDataList struct {
 	Data       []DataDesc `json:"data,omitempty"`
 	Pagination PagStruct  `json:"pagination,omitempty"`
 }
+
+// KeyResp is the response object for retrieving an API key from the
+// gateway's /key endpoint.
+type KeyResp struct {
+	Type string `json:"type,omitempty"`
+	UUID string `json:"uuid,omitempty"`
+}
@@ -0,0 +1,324 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+These are light-weight helpers for talking to the GeoServer that Pz deploys
+layers to, using the information in the DeplStrct that comes back from a
+deployment.  They only cover the parts of WMS and WFS that services have
+needed so far - capabilities, maps, and features as GeoJSON.  GetMap and
+GetFeature requests use WMS 1.1.1 and WFS 1.0.0 respectively, as those
+versions keep bounding boxes in x,y order regardless of projection.
+*/
+
+// WMSCapabilities is the subset of a WMS GetCapabilities response that we
+// care about.  It accepts both 1.1.1 and 1.3.0 responses.
+type WMSCapabilities struct {
+	Version    string     `xml:"version,attr"`
+	MapFormats []string   `xml:"Capability>Request>GetMap>Format"`
+	Layers     []WMSLayer `xml:"Capability>Layer"`
+}
+
+// WMSLayer is a single (possibly nested) layer from a WMS capabilities
+// document.
+type WMSLayer struct {
+	Name       string     `xml:"Name"`
+	Title      string     `xml:"Title"`
+	Abstract   string     `xml:"Abstract"`
+	CRS        []string   `xml:"CRS"`
+	SRS        []string   `xml:"SRS"`
+	BBoxes     []OGCBBox  `xml:"BoundingBox"`
+	GeoBBox    *GeoBBox   `xml:"EX_GeographicBoundingBox"`
+	LatLonBBox *OGCBBox   `xml:"LatLonBoundingBox"`
+	Layers     []WMSLayer `xml:"Layer"`
+}
+
+// OGCBBox is a bounding box in the attribute-based form used by WMS.
+// CRS is "CRS" in WMS 1.3.0 and "SRS" in WMS 1.1.1.
+type OGCBBox struct {
+	CRS  string  `xml:"CRS,attr"`
+	SRS  string  `xml:"SRS,attr"`
+	MinX float64 `xml:"minx,attr"`
+	MinY float64 `xml:"miny,attr"`
+	MaxX float64 `xml:"maxx,attr"`
+	MaxY float64 `xml:"maxy,attr"`
+}
+
+// GeoBBox is the WMS 1.3.0 geographic (EPSG:4326) bounding box.
+type GeoBBox struct {
+	West  float64 `xml:"westBoundLongitude"`
+	East  float64 `xml:"eastBoundLongitude"`
+	South float64 `xml:"southBoundLatitude"`
+	North float64 `xml:"northBoundLatitude"`
+}
+
+// WFSCapabilities is the subset of a WFS GetCapabilities response that we
+// care about.
+type WFSCapabilities struct {
+	Version      string           `xml:"version,attr"`
+	FeatureTypes []WFSFeatureType `xml:"FeatureTypeList>FeatureType"`
+}
+
+// WFSFeatureType is a single feature type from a WFS capabilities document.
+// LowerCorner and UpperCorner are space-separated lon/lat pairs.
+type WFSFeatureType struct {
+	Name        string `xml:"Name"`
+	Title       string `xml:"Title"`
+	Abstract    string `xml:"Abstract"`
+	DefaultCRS  string `xml:"DefaultCRS"`
+	DefaultSRS  string `xml:"DefaultSRS"`
+	SRS         string `xml:"SRS"`
+	LowerCorner string `xml:"WGS84BoundingBox>LowerCorner"`
+	UpperCorner string `xml:"WGS84BoundingBox>UpperCorner"`
+}
+
+// FindLayer searches the capabilities (including nested layers) for the
+// layer with the given name.  GeoServer prefixes layer names with their
+// workspace, so "piazza:abc" will match a name of "abc".  Returns nil if
+// no such layer exists.
+func (c *WMSCapabilities) FindLayer(name string) *WMSLayer {
+	return findWMSLayer(c.Layers, name)
+}
+
+func findWMSLayer(layers []WMSLayer, name string) *WMSLayer {
+	for i := range layers {
+		if ogcNameMatch(layers[i].Name, name) {
+			return &layers[i]
+		}
+		if found := findWMSLayer(layers[i].Layers, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// FindFeatureType searches the capabilities for the feature type with the
+// given name, with the same workspace handling as WMSCapabilities.FindLayer.
+// Returns nil if no such feature type exists.
+func (c *WFSCapabilities) FindFeatureType(name string) *WFSFeatureType {
+	for i := range c.FeatureTypes {
+		if ogcNameMatch(c.FeatureTypes[i].Name, name) {
+			return &c.FeatureTypes[i]
+		}
+	}
+	return nil
+}
+
+func ogcNameMatch(capName, name string) bool {
+	return name != "" && (capName == name || strings.HasSuffix(capName, ":"+name))
+}
+
+// ogcServiceURL returns the base URL for the given OGC service ("wms" or
+// "wfs") on the GeoServer that the deployment lives on.  It is based on
+// CapabilitiesURL where possible, and on Host and Port otherwise.
+func ogcServiceURL(depl *DeplStrct, service string) (string, error) {
+	if depl == nil {
+		return "", ErrWithTrace("No deployment given.")
+	}
+	if depl.CapabilitiesURL != "" {
+		capURL, err := url.Parse(depl.CapabilitiesURL)
+		if err != nil {
+			return "", TraceErr(err)
+		}
+		capURL.RawQuery = ""
+		capURL.Fragment = ""
+		if i := strings.LastIndex(capURL.Path, "/"); i >= 0 {
+			capURL.Path = capURL.Path[:i+1] + service
+		} else {
+			capURL.Path = "/" + service
+		}
+		return capURL.String(), nil
+	}
+	if depl.Host == "" {
+		return "", ErrWithTrace(`Deployment "` + depl.DeplID + `" has neither a capabilities URL nor a host.`)
+	}
+	host := depl.Host
+	if depl.Port != "" {
+		host += ":" + depl.Port
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return host + "/geoserver/" + service, nil
+}
+
+// GetWMSCapabilities retrieves and parses the WMS capabilities of the
+// GeoServer that the deployment lives on.
+func GetWMSCapabilities(depl *DeplStrct, authKey string) (*WMSCapabilities, error) {
+	return GetWMSCapabilitiesContext(context.Background(), depl, authKey)
+}
+
+// GetWMSCapabilitiesContext is GetWMSCapabilities within the trace of the
+// given context.  Ending the context aborts the call.
+func GetWMSCapabilitiesContext(ctx context.Context, depl *DeplStrct, authKey string) (*WMSCapabilities, error) {
+	base, err := ogcServiceURL(depl, "wms")
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	var caps WMSCapabilities
+	if err = requestKnownXML(ctx, base+"?service=WMS&version=1.3.0&request=GetCapabilities", authKey, &caps); err != nil {
+		return nil, TraceErr(err)
+	}
+	return &caps, nil
+}
+
+// GetWFSCapabilities retrieves and parses the WFS capabilities of the
+// GeoServer that the deployment lives on.
+func GetWFSCapabilities(depl *DeplStrct, authKey string) (*WFSCapabilities, error) {
+	base, err := ogcServiceURL(depl, "wfs")
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	var caps WFSCapabilities
+	if err = requestKnownXML(context.Background(), base+"?service=WFS&version=2.0.0&request=GetCapabilities", authKey, &caps); err != nil {
+		return nil, TraceErr(err)
+	}
+	return &caps, nil
+}
+
+// requestKnownXML is the XML equivalent of RequestKnownJSONContext, for GET
+// calls.
+func requestKnownXML(ctx context.Context, address, authKey string, outpObj interface{}) error {
+	resp, err := SubmitSinglePartContext(ctx, "GET", "", address, authKey)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return TraceErr(err)
+	}
+	byts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return TraceErr(err)
+	}
+	if err = xml.Unmarshal(byts, outpObj); err != nil {
+		return ErrWithTrace("XML unmarshal failed: " + err.Error() + ".  Original input: " + string(byts) + ".")
+	}
+	return nil
+}
+
+// ogcBBoxParams converts spatial metadata into the bbox and SRS values used
+// by GetMap and GetFeature calls.  An EpsgCode of 0 is taken as EPSG:4326.
+func ogcBBoxParams(sMeta *SpatMeta) (string, string, error) {
+	if sMeta == nil {
+		return "", "", ErrWithTrace("No spatial metadata given.")
+	}
+	if sMeta.MinX >= sMeta.MaxX || sMeta.MinY >= sMeta.MaxY {
+		return "", "", ErrWithTrace("Spatial metadata does not describe a valid bounding box.")
+	}
+	epsg := sMeta.EpsgCode
+	if epsg == 0 {
+		epsg = 4326
+	}
+	bbox := SliceToCommaSep([]string{
+		strconv.FormatFloat(sMeta.MinX, 'f', -1, 64),
+		strconv.FormatFloat(sMeta.MinY, 'f', -1, 64),
+		strconv.FormatFloat(sMeta.MaxX, 'f', -1, 64),
+		strconv.FormatFloat(sMeta.MaxY, 'f', -1, 64)})
+	return bbox, "EPSG:" + strconv.Itoa(epsg), nil
+}
+
+// GetMapURL builds a WMS GetMap URL for the deployed layer, covering the
+// extent given by sMeta.  Format defaults to "image/png".
+func GetMapURL(depl *DeplStrct, sMeta *SpatMeta, width, height int, format string) (string, error) {
+	base, err := ogcServiceURL(depl, "wms")
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	bbox, srs, err := ogcBBoxParams(sMeta)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	if width <= 0 || height <= 0 {
+		return "", ErrWithTrace("GetMap requires a positive width and height.")
+	}
+	if format == "" {
+		format = "image/png"
+	}
+	params := url.Values{}
+	params.Set("service", "WMS")
+	params.Set("version", "1.1.1")
+	params.Set("request", "GetMap")
+	params.Set("layers", depl.Layer)
+	params.Set("styles", "")
+	params.Set("bbox", bbox)
+	params.Set("srs", srs)
+	params.Set("width", strconv.Itoa(width))
+	params.Set("height", strconv.Itoa(height))
+	params.Set("format", format)
+	params.Set("transparent", "true")
+	return base + "?" + params.Encode(), nil
+}
+
+// GetFeatureURL builds a WFS GetFeature URL for the deployed layer that
+// returns GeoJSON.  If sMeta is non-nil, the features are restricted to
+// its extent.  A maxFeatures of zero or less returns all features.
+func GetFeatureURL(depl *DeplStrct, sMeta *SpatMeta, maxFeatures int) (string, error) {
+	base, err := ogcServiceURL(depl, "wfs")
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	params := url.Values{}
+	params.Set("service", "WFS")
+	params.Set("version", "1.0.0")
+	params.Set("request", "GetFeature")
+	params.Set("typeName", depl.Layer)
+	params.Set("outputFormat", "application/json")
+	if sMeta != nil {
+		bbox, srs, err := ogcBBoxParams(sMeta)
+		if err != nil {
+			return "", TraceErr(err)
+		}
+		params.Set("bbox", bbox)
+		params.Set("srsName", srs)
+	}
+	if maxFeatures > 0 {
+		params.Set("maxFeatures", strconv.Itoa(maxFeatures))
+	}
+	return base + "?" + params.Encode(), nil
+}
+
+// GetFeatures retrieves the features of the deployed layer, as per
+// GetFeatureURL, and returns them as GeoJSON.
+func GetFeatures(depl *DeplStrct, sMeta *SpatMeta, maxFeatures int, authKey string) ([]byte, error) {
+	address, err := GetFeatureURL(depl, sMeta, maxFeatures)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	resp, err := SubmitSinglePart("GET", "", address, authKey)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	byts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	// GeoServer reports WFS errors as XML with a 200 status.
+	if trimmed := strings.TrimSpace(string(byts)); strings.HasPrefix(trimmed, "<") {
+		return nil, ErrWithTrace("GetFeature did not return GeoJSON: " + trimmed)
+	}
+	return byts, nil
+}
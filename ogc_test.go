@@ -0,0 +1,112 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestOGCCapabilities(t *testing.T) {
+	wmsCaps := `<?xml version="1.0"?>
+<WMS_Capabilities version="1.3.0" xmlns="http://www.opengis.net/wms">
+  <Capability>
+    <Request><GetMap><Format>image/png</Format><Format>image/jpeg</Format></GetMap></Request>
+    <Layer>
+      <Title>GeoServer</Title>
+      <Layer queryable="1">
+        <Name>piazza:testLayer</Name>
+        <CRS>EPSG:4326</CRS>
+        <EX_GeographicBoundingBox>
+          <westBoundLongitude>-10</westBoundLongitude><eastBoundLongitude>10</eastBoundLongitude>
+          <southBoundLatitude>-5</southBoundLatitude><northBoundLatitude>5</northBoundLatitude>
+        </EX_GeographicBoundingBox>
+        <BoundingBox CRS="EPSG:4326" minx="-5" miny="-10" maxx="5" maxy="10"/>
+      </Layer>
+    </Layer>
+  </Capability>
+</WMS_Capabilities>`
+	wfsCaps := `<?xml version="1.0"?>
+<wfs:WFS_Capabilities version="2.0.0" xmlns:wfs="http://www.opengis.net/wfs/2.0" xmlns:ows="http://www.opengis.net/ows/1.1">
+  <FeatureTypeList>
+    <FeatureType>
+      <Name>piazza:testLayer</Name>
+      <DefaultCRS>urn:ogc:def:crs:EPSG::4326</DefaultCRS>
+      <ows:WGS84BoundingBox><ows:LowerCorner>-10 -5</ows:LowerCorner><ows:UpperCorner>10 5</ows:UpperCorner></ows:WGS84BoundingBox>
+    </FeatureType>
+  </FeatureTypeList>
+</wfs:WFS_Capabilities>`
+	SetMockClient([]string{wmsCaps, wfsCaps}, 200)
+	depl := &DeplStrct{CapabilitiesURL: "http://geo.test:8080/geoserver/piazza/wfs?service=wfs&request=GetCapabilities", Layer: "testLayer"}
+
+	wms, err := GetWMSCapabilities(depl, "")
+	if err != nil {
+		t.Fatal(`TestOGCCapabilities: WMS error: ` + err.Error())
+	}
+	layer := wms.FindLayer(depl.Layer)
+	if layer == nil || layer.GeoBBox == nil || layer.GeoBBox.West != -10 || len(wms.MapFormats) != 2 {
+		t.Errorf(`TestOGCCapabilities: WMS capabilities parsed incorrectly: %#v`, wms)
+	}
+
+	wfs, err := GetWFSCapabilities(depl, "")
+	if err != nil {
+		t.Fatal(`TestOGCCapabilities: WFS error: ` + err.Error())
+	}
+	fType := wfs.FindFeatureType(depl.Layer)
+	if fType == nil || fType.LowerCorner != "-10 -5" {
+		t.Errorf(`TestOGCCapabilities: WFS capabilities parsed incorrectly: %#v`, wfs)
+	}
+}
+
+func TestOGCURLs(t *testing.T) {
+	depl := &DeplStrct{Host: "geo.test", Port: "8080", Layer: "testLayer"}
+	sMeta := &SpatMeta{MinX: -10, MinY: -5, MaxX: 10, MaxY: 5}
+
+	mapURL, err := GetMapURL(depl, sMeta, 256, 128, "")
+	if err != nil {
+		t.Fatal(`TestOGCURLs: GetMapURL error: ` + err.Error())
+	}
+	parsed, _ := url.Parse(mapURL)
+	query := parsed.Query()
+	if parsed.Host != "geo.test:8080" || parsed.Path != "/geoserver/wms" ||
+		query.Get("bbox") != "-10,-5,10,5" || query.Get("srs") != "EPSG:4326" || query.Get("layers") != "testLayer" {
+		t.Error(`TestOGCURLs: bad GetMap URL: ` + mapURL)
+	}
+
+	featURL, err := GetFeatureURL(depl, nil, 10)
+	if err != nil {
+		t.Fatal(`TestOGCURLs: GetFeatureURL error: ` + err.Error())
+	}
+	parsed, _ = url.Parse(featURL)
+	query = parsed.Query()
+	if parsed.Path != "/geoserver/wfs" || query.Get("maxFeatures") != "10" || query.Get("bbox") != "" {
+		t.Error(`TestOGCURLs: bad GetFeature URL: ` + featURL)
+	}
+
+	if _, err = GetMapURL(depl, &SpatMeta{}, 256, 256, ""); err == nil {
+		t.Error(`TestOGCURLs: passed on empty bounding box.`)
+	}
+	if _, err = GetMapURL(&DeplStrct{}, sMeta, 256, 256, ""); err == nil {
+		t.Error(`TestOGCURLs: passed on deployment without a location.`)
+	}
+
+	SetMockClient([]string{`{"type":"FeatureCollection","features":[]}`, `<ServiceExceptionReport/>`}, 200)
+	if _, err = GetFeatures(depl, sMeta, 0, ""); err != nil {
+		t.Error(`TestOGCURLs: GetFeatures error: ` + err.Error())
+	}
+	if _, err = GetFeatures(depl, sMeta, 0, ""); err == nil {
+		t.Error(`TestOGCURLs: GetFeatures passed on exception report.`)
+	}
+}
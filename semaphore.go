@@ -14,21 +14,40 @@
 
 package pzsvc
 
-import ()
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
 
 type empty struct{}
 
 // Semaphore is a structure that allows limited resources to be
 // allocated across multiple threads.  Semaphores that are left
-// nil provide no restriction to behavior.
+// nil provide no restriction to behavior.  Being a bare channel, it
+// can report how many resources are held (InUse), but not how many
+// callers are waiting, and every claim is for a single resource.
+// Waiter counts and weighted claims need state that a channel cannot
+// carry, so they are provided by WeightedSemaphore instead.  Callers
+// that only ever claim one resource at a time, such as BulkIngest,
+// take a Semaphore.
 type Semaphore chan empty
 
+// NewSemaphore returns a Semaphore with the given number of resources.
+// A size of zero or less returns a nil Semaphore, which provides no
+// restriction.
+func NewSemaphore(size int) Semaphore {
+	if size <= 0 {
+		return nil
+	}
+	return make(Semaphore, size)
+}
+
 // Lock claims a resource, or waits if one is not available.
 func (s Semaphore) Lock() {
-	if s != nil {
-		var e empty
-		s <- e
-	}
+	s.LockContext(context.Background())
 }
 
 // Unlock releases a resource.
@@ -37,3 +56,253 @@ func (s Semaphore) Unlock() {
 		<-s
 	}
 }
+
+// TryLock claims a resource if one is immediately available, and
+// returns whether it did so.
+func (s Semaphore) TryLock() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- empty{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// LockContext claims a resource, waiting until one is available or
+// the context is done.  It returns the context's error in the latter
+// case, in which case nothing has been claimed.
+func (s Semaphore) LockContext(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	if s.TryLock() {
+		metrics().SemaphoreWaited(0, true)
+		return nil
+	}
+	start := time.Now()
+	select {
+	case s <- empty{}:
+		metrics().SemaphoreWaited(time.Since(start), true)
+		return nil
+	case <-ctx.Done():
+		metrics().SemaphoreWaited(time.Since(start), false)
+		return ctx.Err()
+	}
+}
+
+// LockTimeout claims a resource, waiting no longer than the given
+// duration.  It returns whether the resource was claimed.
+func (s Semaphore) LockTimeout(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.LockContext(ctx) == nil
+}
+
+// Size returns the total number of resources in the Semaphore.  A nil
+// Semaphore returns zero.
+func (s Semaphore) Size() int {
+	return cap(s)
+}
+
+// InUse returns the number of resources currently claimed.
+func (s Semaphore) InUse() int {
+	return len(s)
+}
+
+// WeightedSemaphore is a Semaphore whose resources may also be claimed
+// several at a time, for heavier jobs, through Acquire and Release.
+// Waiters are served in the order they arrived, so a large claim will not
+// be starved by a stream of small ones.  WeightedSemaphores that are left
+// nil provide no restriction to behavior, as does the zero value.
+type WeightedSemaphore struct {
+	lock    sync.Mutex
+	size    int
+	inUse   int
+	waiters list.List // of *semWaiter
+}
+
+type semWaiter struct {
+	n     int
+	ready chan empty // closed once the claim has been granted
+}
+
+// NewWeightedSemaphore returns a WeightedSemaphore with the given number
+// of resources.  A size of zero or less returns nil, which provides no
+// restriction.
+func NewWeightedSemaphore(size int) *WeightedSemaphore {
+	if size <= 0 {
+		return nil
+	}
+	return &WeightedSemaphore{size: size}
+}
+
+// Lock claims a resource, or waits if one is not available.
+func (s *WeightedSemaphore) Lock() {
+	s.AcquireContext(context.Background(), 1)
+}
+
+// Unlock releases a resource.
+func (s *WeightedSemaphore) Unlock() {
+	s.Release(1)
+}
+
+// TryLock claims a resource if one is immediately available, and
+// returns whether it did so.
+func (s *WeightedSemaphore) TryLock() bool {
+	return s.TryAcquire(1)
+}
+
+// LockContext claims a resource, waiting until one is available or
+// the context is done.  It returns the context's error in the latter
+// case, in which case nothing has been claimed.
+func (s *WeightedSemaphore) LockContext(ctx context.Context) error {
+	return s.AcquireContext(ctx, 1)
+}
+
+// LockTimeout claims a resource, waiting no longer than the given
+// duration.  It returns whether the resource was claimed.
+func (s *WeightedSemaphore) LockTimeout(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.AcquireContext(ctx, 1) == nil
+}
+
+// Acquire claims n resources at once, waiting until all of them are
+// available.  It fails if n is more than the WeightedSemaphore holds.
+func (s *WeightedSemaphore) Acquire(n int) error {
+	return s.AcquireContext(context.Background(), n)
+}
+
+// AcquireContext claims n resources at once, waiting until all of them
+// are available or the context is done.  On failure, nothing has been
+// claimed.
+func (s *WeightedSemaphore) AcquireContext(ctx context.Context, n int) error {
+	if s.unrestricted() || n <= 0 {
+		return nil
+	}
+	if n > s.size {
+		return ErrWithTrace(fmt.Sprintf("Cannot acquire %d resources from a semaphore of size %d.", n, s.size))
+	}
+
+	s.lock.Lock()
+	if s.waiters.Len() == 0 && s.inUse+n <= s.size {
+		s.inUse += n
+		s.lock.Unlock()
+		metrics().SemaphoreWaited(0, true)
+		return nil
+	}
+	waiter := &semWaiter{n: n, ready: make(chan empty)}
+	elem := s.waiters.PushBack(waiter)
+	s.lock.Unlock()
+
+	start := time.Now()
+	select {
+	case <-waiter.ready:
+		metrics().SemaphoreWaited(time.Since(start), true)
+		return nil
+	case <-ctx.Done():
+		metrics().SemaphoreWaited(time.Since(start), false)
+		s.lock.Lock()
+		select {
+		case <-waiter.ready:
+			// granted while we were giving up.  Hand it back.
+			s.inUse -= n
+			s.notifyWaiters()
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			if isFront {
+				s.notifyWaiters()
+			}
+		}
+		s.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire claims n resources if they are all immediately available,
+// and returns whether it did so.
+func (s *WeightedSemaphore) TryAcquire(n int) bool {
+	if s.unrestricted() || n <= 0 {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.waiters.Len() == 0 && s.inUse+n <= s.size {
+		s.inUse += n
+		return true
+	}
+	return false
+}
+
+// Release releases n resources.  Releasing more than are currently
+// claimed is a programming error, and panics.
+func (s *WeightedSemaphore) Release(n int) {
+	if s.unrestricted() || n <= 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n > s.inUse {
+		panic(fmt.Sprintf("pzsvc: released %d semaphore resources with only %d claimed", n, s.inUse))
+	}
+	s.inUse -= n
+	s.notifyWaiters()
+}
+
+// notifyWaiters grants claims to waiters, in order, for as long as there
+// is room.  Must be called with the lock held.
+func (s *WeightedSemaphore) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		waiter := front.Value.(*semWaiter)
+		if s.inUse+waiter.n > s.size {
+			return
+		}
+		s.inUse += waiter.n
+		s.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
+
+// unrestricted reports whether the WeightedSemaphore is nil or the zero
+// value, neither of which restricts anything.
+func (s *WeightedSemaphore) unrestricted() bool {
+	return s == nil || s.size <= 0
+}
+
+// Size returns the total number of resources in the WeightedSemaphore.  A
+// nil WeightedSemaphore returns zero.
+func (s *WeightedSemaphore) Size() int {
+	if s == nil {
+		return 0
+	}
+	return s.size
+}
+
+// InUse returns the number of resources currently claimed.
+func (s *WeightedSemaphore) InUse() int {
+	if s == nil {
+		return 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.inUse
+}
+
+// Waiting returns the number of callers currently waiting to claim
+// resources.
+func (s *WeightedSemaphore) Waiting() int {
+	if s == nil {
+		return 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.waiters.Len()
+}
@@ -0,0 +1,113 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreNil(t *testing.T) {
+	var sem Semaphore
+	sem.Lock()
+	sem.Unlock()
+	if !sem.TryLock() || sem.LockContext(context.Background()) != nil || sem.InUse() != 0 {
+		t.Error(`TestSemaphoreNil: nil semaphore restricted behavior.`)
+	}
+	if NewSemaphore(0) != nil {
+		t.Error(`TestSemaphoreNil: zero-sized semaphore was not nil.`)
+	}
+
+	var wSem *WeightedSemaphore
+	wSem.Lock()
+	wSem.Unlock()
+	if !wSem.TryLock() || wSem.Acquire(100) != nil || wSem.InUse() != 0 {
+		t.Error(`TestSemaphoreNil: nil weighted semaphore restricted behavior.`)
+	}
+	var zero WeightedSemaphore
+	zero.Lock()
+	zero.Unlock()
+	if !zero.TryLock() || zero.Acquire(100) != nil || zero.InUse() != 0 {
+		t.Error(`TestSemaphoreNil: zero-value weighted semaphore restricted behavior.`)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	sem := make(Semaphore, 1)
+	if !sem.TryLock() {
+		t.Error(`TestSemaphore: TryLock failed with room available.`)
+	}
+	if sem.TryLock() {
+		t.Error(`TestSemaphore: TryLock passed on full semaphore.`)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if sem.LockContext(ctx) == nil {
+		t.Error(`TestSemaphore: LockContext passed on full semaphore.`)
+	}
+	if sem.LockTimeout(time.Millisecond) {
+		t.Error(`TestSemaphore: LockTimeout passed on full semaphore.`)
+	}
+	sem.Unlock()
+	if !sem.LockTimeout(time.Millisecond) || sem.InUse() != 1 || sem.Size() != 1 {
+		t.Error(`TestSemaphore: LockTimeout failed with room available.`)
+	}
+}
+
+func TestSemaphoreWeighted(t *testing.T) {
+	sem := NewWeightedSemaphore(3)
+	if err := sem.Acquire(2); err != nil {
+		t.Fatal(`TestSemaphoreWeighted: failed on initial acquire: ` + err.Error())
+	}
+	if !sem.TryLock() {
+		t.Error(`TestSemaphoreWeighted: TryLock failed with room available.`)
+	}
+	if sem.TryLock() {
+		t.Error(`TestSemaphoreWeighted: TryLock passed on full semaphore.`)
+	}
+	if sem.Acquire(4) == nil {
+		t.Error(`TestSemaphoreWeighted: passed on acquire larger than semaphore.`)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if sem.LockContext(ctx) == nil {
+		t.Error(`TestSemaphoreWeighted: LockContext passed on full semaphore.`)
+	}
+	if sem.LockTimeout(time.Millisecond) {
+		t.Error(`TestSemaphoreWeighted: LockTimeout passed on full semaphore.`)
+	}
+
+	done := make(chan empty)
+	go func() {
+		sem.Acquire(2)
+		close(done)
+	}()
+	for sem.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	sem.Unlock()
+	select {
+	case <-done:
+		t.Error(`TestSemaphoreWeighted: weighted acquire granted without room.`)
+	case <-time.After(10 * time.Millisecond):
+	}
+	sem.Release(1)
+	<-done
+	if sem.InUse() != 3 || sem.Waiting() != 0 {
+		t.Errorf(`TestSemaphoreWeighted: bad final state.  InUse: %d, Waiting: %d`, sem.InUse(), sem.Waiting())
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+/*
+This is a small toolkit for the service side of things.  A Router is an
+http.ServeMux with a stack of Middleware around it.  Routes are registered
+with HandlerFuncs, which handle CORS preflight through Preflight (unless a
+CORS policy is in use) and report failure by returning an error, which is
+written out as an Error JSON body.
+An HTTPError carries its own status code; anything else is a 500.
+*/
+
+// DefaultMaxBodyBytes is the request body limit applied by NewRouter.
+const DefaultMaxBodyBytes = 10 << 20
+
+// RequestIDHeader is the header through which request IDs are received
+// and returned.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// HandlerFunc is an http handler that reports failure by returning an error,
+// rather than writing it out itself.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Middleware wraps an http.Handler in another.
+type Middleware func(http.Handler) http.Handler
+
+// Router routes requests through its middleware to the handlers registered
+// on it.  Middleware is applied in the order given, the first being the
+// outermost.
+type Router struct {
+	mux        *http.ServeMux
+	middleware []Middleware
+}
+
+// NewRouter creates a Router with panic recovery, request IDs, request
+// logging and a body limit of DefaultMaxBodyBytes, followed by any
+// additional middleware given (such as Tracing).
+func NewRouter(middleware ...Middleware) *Router {
+	rt := &Router{mux: http.NewServeMux()}
+	rt.Use(Recoverer, RequestIDs, RequestLogger, BodyLimit(DefaultMaxBodyBytes))
+	rt.Use(middleware...)
+	return rt
+}
+
+// Use adds middleware to the Router, inside that already added.
+func (rt *Router) Use(middleware ...Middleware) {
+	rt.middleware = append(rt.middleware, middleware...)
+}
+
+// Handle registers a plain http.Handler for the given ServeMux pattern.
+func (rt *Router) Handle(pattern string, handler http.Handler) {
+	rt.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a HandlerFunc for the given ServeMux pattern, as per
+// Handler.
+func (rt *Router) HandleFunc(pattern string, handler HandlerFunc) {
+	rt.mux.Handle(pattern, Handler(handler))
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var handler http.Handler = rt.mux
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		handler = rt.middleware[i](handler)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// Handler turns a HandlerFunc into an http.Handler.  OPTIONS requests are
+// answered through Preflight without calling the HandlerFunc - or, if the
+// request has been through CORS middleware, answered without adding any
+// further CORS headers.  Errors returned are written out through WriteError,
+// with the trace ID as the correlation ID if the request is traced.
+func Handler(handler HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isOptions := r.Method == "OPTIONS"
+		if !corsApplied(r) {
+			isOptions = Preflight(w, r)
+		}
+		if isOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err := handler(w, r); err != nil {
+			corrID := ""
+			if tc, ok := TraceFromContext(r.Context()); ok {
+				corrID = tc.TraceID
+			}
+			writeError(w, err, corrID)
+		}
+	})
+}
+
+// WriteError writes the given error out as an Error JSON body.  The status
+// is taken from an HTTPError or *HTTPError, is 413 if the request body was
+// too large, and is 500 otherwise.
+func WriteError(w http.ResponseWriter, err error) {
+	writeError(w, err, "")
+}
+
+// writeError is WriteError, with a correlation ID included if not empty.
+func writeError(w http.ResponseWriter, err error, corrID string) {
+	var (
+		status   = http.StatusInternalServerError
+		httpErr  HTTPError
+		httpErrP *HTTPError
+		sizeErr  *http.MaxBytesError
+		message  = err.Error()
+	)
+	switch {
+	case errors.As(err, &httpErr):
+		status, message = httpErr.Status, httpErr.Message
+	case errors.As(err, &httpErrP) && httpErrP != nil:
+		status, message = httpErrP.Status, httpErrP.Message
+	case errors.As(err, &sizeErr):
+		status = http.StatusRequestEntityTooLarge
+	}
+	w.Header().Set("Content-Type", "application/json")
+	PrintJSON(w, Error{Message: message, CorrelationID: corrID}, status)
+}
+
+// DecodeJSON decodes the body of the request into the given object.
+// Failures are returned as HTTPErrors - 413 if the body was too large, 400
+// if it was not valid JSON.
+func DecodeJSON(r *http.Request, output interface{}) error {
+	if r.Body == nil {
+		return &HTTPError{Status: http.StatusBadRequest, Message: "Request has no body."}
+	}
+	byts, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		var sizeErr *http.MaxBytesError
+		if errors.As(err, &sizeErr) {
+			return &HTTPError{Status: http.StatusRequestEntityTooLarge, Message: "Request body too large."}
+		}
+		return &HTTPError{Status: http.StatusBadRequest, Message: "Could not read request body: " + err.Error()}
+	}
+	if err = json.Unmarshal(byts, output); err != nil {
+		return &HTTPError{Status: http.StatusBadRequest, Message: "Invalid JSON in request body: " + err.Error()}
+	}
+	return nil
+}
+
+// Recoverer is middleware that turns panics in later handlers into 500
+// responses, logging the stack.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				WriteError(w, &HTTPError{Status: http.StatusInternalServerError, Message: "Internal server error."})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestIDs is middleware that gives each request an ID - the one in its
+// X-Request-ID header if present, or a new one otherwise - and returns it
+// in the X-Request-ID header of the response.  Handlers can retrieve it
+// through RequestID.
+func RequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID := r.Header.Get(RequestIDHeader)
+		if reqID == "" {
+			reqID, _ = PsuUUID()
+			r.Header.Set(RequestIDHeader, reqID)
+		}
+		w.Header().Set(RequestIDHeader, reqID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, reqID)))
+	})
+}
+
+// RequestID returns the request ID stored in the context by RequestIDs, or
+// the empty string if there is none.
+func RequestID(ctx context.Context) string {
+	reqID, _ := ctx.Value(requestIDKey{}).(string)
+	return reqID
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(byts []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(byts)
+	rec.size += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// RequestLogger is middleware that logs the method, path, status, size and
+// duration of each request, along with its request ID if it has one.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("[%s] %s %s %d %dB %v", RequestID(r.Context()), r.Method, r.URL.Path, rec.status, rec.size, time.Since(start))
+	})
+}
+
+// BodyLimit returns middleware that limits request bodies to the given
+// number of bytes.  Reading past the limit fails, and DecodeJSON and
+// WriteError report it as a 413.
+func BodyLimit(maxBytes int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveTest(rt *Router, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouter(t *testing.T) {
+	type echoInput struct {
+		Name string `json:"name"`
+	}
+	rt := NewRouter()
+	rt.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) error {
+		var inp echoInput
+		if err := DecodeJSON(r, &inp); err != nil {
+			return err
+		}
+		PrintJSON(w, inp, http.StatusOK)
+		return nil
+	})
+	rt.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) error {
+		return HTTPError{Status: http.StatusTeapot, Message: "short and stout"}
+	})
+	rt.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("plain failure")
+	})
+	rt.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) error {
+		panic("oops")
+	})
+	rt.HandleFunc("/id", func(w http.ResponseWriter, r *http.Request) error {
+		HTTPOut(w, RequestID(r.Context()), http.StatusOK)
+		return nil
+	})
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		output             string
+	}{
+		{"POST", "/echo", `{"name":"bob"}`, 200, `{"name":"bob"}`},
+		{"POST", "/echo", `{"name":`, 400, `{"error":"Invalid JSON in request body: unexpected end of JSON input"}`},
+		{"POST", "/echo", `{"name":"` + strings.Repeat("x", DefaultMaxBodyBytes) + `"}`, 413, `{"error":"Request body too large."}`},
+		{"GET", "/teapot", "", 418, `{"error":"short and stout"}`},
+		{"GET", "/plain", "", 500, `{"error":"plain failure"}`},
+		{"GET", "/panic", "", 500, `{"error":"Internal server error."}`},
+		{"OPTIONS", "/teapot", "", 200, ``},
+	}
+	for _, tc := range cases {
+		rec := serveTest(rt, tc.method, tc.path, tc.body, nil)
+		if rec.Code != tc.status || rec.Body.String() != tc.output {
+			t.Errorf(`TestRouter: %s %s gave %d "%s", expected %d "%s"`, tc.method, tc.path, rec.Code, rec.Body.String(), tc.status, tc.output)
+		}
+		if rec.Header().Get(RequestIDHeader) == "" {
+			t.Errorf(`TestRouter: %s %s gave no request ID.`, tc.method, tc.path)
+		}
+	}
+
+	rec := serveTest(rt, "GET", "/id", "", map[string]string{RequestIDHeader: "req-123"})
+	if rec.Body.String() != "req-123" || rec.Header().Get(RequestIDHeader) != "req-123" {
+		t.Errorf(`TestRouter: request ID not propagated: "%s"`, rec.Body.String())
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	rt := NewRouter(tag("first"), tag("second"))
+	rt.Handle("/plain", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+	serveTest(rt, "GET", "/plain", "", nil)
+	if strings.Join(order, ",") != "first,second,handler" {
+		t.Errorf(`TestRouterMiddleware: bad order: %v`, order)
+	}
+
+	rec := httptest.NewRecorder()
+	WriteError(rec, &HTTPError{Status: http.StatusNotFound, Message: "gone"})
+	if rec.Code != http.StatusNotFound || rec.Body.String() != `{"error":"gone"}` {
+		t.Errorf(`TestRouterMiddleware: bad *HTTPError output: %d "%s"`, rec.Code, rec.Body.String())
+	}
+}
@@ -15,6 +15,7 @@
 package pzsvc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -49,13 +50,25 @@ func FindMySvc(svcName, pzAddr, authKey string) (string, error) {
 func ManageRegistration(svcName, svcDesc, svcURL, pzAddr, svcVers, authKey string,
 	attributes map[string]string) error {
 
+	return ManageRegistrationWithClass(svcName, svcDesc, svcURL, pzAddr, svcVers, authKey, DefaultClassType(), attributes)
+}
+
+// ManageRegistrationWithClass is ManageRegistration, with the service marked with
+// the given classification rather than the default.  The classification must pass
+// ValidateClassType.
+func ManageRegistrationWithClass(svcName, svcDesc, svcURL, pzAddr, svcVers, authKey string,
+	svcClass ClassType, attributes map[string]string) error {
+
+	if err := ValidateClassType(svcClass); err != nil {
+		return TraceErr(err)
+	}
+
 	fmt.Println("Finding")
 	svcID, err := FindMySvc(svcName, pzAddr, authKey)
 	if err != nil {
 		return TraceErr(err)
 	}
 
-	svcClass := ClassType{"UNCLASSIFIED"} // TODO: this will have to be updated at some point.
 	metaObj := ResMeta{Name: svcName,
 		Description: svcDesc,
 		ClassType:   svcClass,
@@ -82,12 +95,24 @@ func ManageRegistration(svcName, svcDesc, svcURL, pzAddr, svcVers, authKey strin
 }
 
 // TestPiazzaAuth returns an error if it is unable to authenticate
-// with the gateway and authorization provided
+// with the gateway and authorization provided.  If the gateway answered,
+// the error is an *HTTPError carrying the status it answered with.
 func TestPiazzaAuth(pzGateway, auth string) error {
+	return piazzaAuth(context.Background(), pzGateway, auth)
+}
+
+// piazzaAuth is TestPiazzaAuth within the given context.
+func piazzaAuth(ctx context.Context, pzGateway, auth string) error {
 
 	if pzGateway == "" {
 		return &HTTPError{Message: "This request requires a 'pzGateway'.", Status: http.StatusBadRequest}
 	}
-	_, err := SubmitSinglePart("GET", "", pzGateway+"/eventType", auth)
+	resp, err := SubmitSinglePartContext(ctx, "GET", "", pzGateway+"/eventType", auth)
+	if resp != nil {
+		resp.Body.Close()
+		if err != nil {
+			return &HTTPError{Message: err.Error(), Status: resp.StatusCode}
+		}
+	}
 	return err
 }
@@ -17,8 +17,9 @@ package pzsvc
 import (
 	"encoding/json"
 	//"fmt"
-	//"net/http"
+	"net/http"
 	//"net/url"
+	"strings"
 	"testing"
 )
 
@@ -52,3 +53,36 @@ func TestManageRegistration(t *testing.T) {
 		t.Error(`TestManageRegistration: failed on empty registration.  Error: `, err.Error())
 	}
 }
+
+type closeRecorder struct {
+	*strings.Reader
+	closed *int
+}
+
+func (cr closeRecorder) Close() error {
+	*cr.closed++
+	return nil
+}
+
+func TestPiazzaAuthBody(t *testing.T) {
+	prev := HTTPClient()
+	defer SetHTTPClient(prev)
+
+	closed, status := 0, http.StatusOK
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       closeRecorder{strings.NewReader(`{}`), &closed},
+			Header:     make(http.Header)}, nil
+	})})
+
+	if err := TestPiazzaAuth("http://testURL.net", "Basic good"); err != nil || closed != 1 {
+		t.Errorf(`TestPiazzaAuthBody: accepted auth gave %v, with the body closed %d times.`, err, closed)
+	}
+	status = http.StatusUnauthorized
+	err := TestPiazzaAuth("http://testURL.net", "Basic bad")
+	if httpErr, ok := err.(*HTTPError); !ok || httpErr.Status != http.StatusUnauthorized {
+		t.Errorf(`TestPiazzaAuthBody: rejected auth gave %#v`, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// shpRequiredExts are the shapefile components that Pz requires in an
+// ingested zip.  shpOptionalExts are the others we recognize and pass
+// along if present.
+var (
+	shpRequiredExts = []string{".shp", ".shx", ".dbf", ".prj"}
+	shpOptionalExts = []string{".cpg", ".sbn", ".sbx", ".qix", ".fix", ".aih", ".ain", ".shp.xml"}
+)
+
+// shpSplit splits a file name into its stem and its (lower-cased)
+// shapefile component extension.  The extension is empty if the file is
+// not a recognized shapefile component.
+func shpSplit(fName string) (string, string) {
+	base := filepath.Base(fName)
+	lower := strings.ToLower(base)
+	for _, exts := range [][]string{shpOptionalExts, shpRequiredExts} {
+		for _, ext := range exts {
+			if strings.HasSuffix(lower, ext) && len(base) > len(ext) {
+				return base[:len(base)-len(ext)], ext
+			}
+		}
+	}
+	return base, ""
+}
+
+// CheckShapefileParts verifies that the given file names make up a single
+// complete shapefile: one of each required component, all with the same
+// base name, and nothing unrecognized.  It returns the shared base name.
+func CheckShapefileParts(fNames []string) (string, error) {
+	var (
+		stem  string
+		found = make(map[string]bool)
+	)
+	for _, fName := range fNames {
+		fStem, ext := shpSplit(fName)
+		if ext == "" {
+			return "", ErrWithTrace(`File "` + fName + `" is not a recognized shapefile component.`)
+		}
+		if stem == "" {
+			stem = fStem
+		} else if fStem != stem {
+			return "", ErrWithTrace(`Shapefile components "` + stem + `" and "` + fStem + `" have different names.`)
+		}
+		if found[ext] {
+			return "", ErrWithTrace(`Shapefile has more than one "` + ext + `" component.`)
+		}
+		found[ext] = true
+	}
+	var missing []string
+	for _, ext := range shpRequiredExts {
+		if !found[ext] {
+			missing = append(missing, ext)
+		}
+	}
+	if len(missing) != 0 {
+		return "", ErrWithTrace("Shapefile is missing required components: " + SliceToCommaSep(missing))
+	}
+	return stem, nil
+}
+
+// WriteShapefileZip validates the given shapefile components, as per
+// CheckShapefileParts, and streams them from fsys into a zip on the given
+// writer.  Directories are stripped from the names inside the zip.
+func WriteShapefileZip(w io.Writer, fsys FileSystem, fNames []string) error {
+	if _, err := CheckShapefileParts(fNames); err != nil {
+		return TraceErr(err)
+	}
+	zipW := zip.NewWriter(w)
+	for _, fName := range fNames {
+		if err := addZipFile(zipW, fsys, fName); err != nil {
+			return TraceErr(err)
+		}
+	}
+	return TraceErr(zipW.Close())
+}
+
+func addZipFile(zipW *zip.Writer, fsys FileSystem, fName string) error {
+	in, err := fsys.Open(fName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := zipW.Create(filepath.Base(fName))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// ZipShapefile is WriteShapefileZip into memory.
+func ZipShapefile(fsys FileSystem, fNames []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteShapefileZip(&buf, fsys, fNames); err != nil {
+		return nil, TraceErr(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ShapefileDirParts finds the shapefile components at the top level of the
+// given FileSystem, which must be one that supports listing.  Files that
+// are not shapefile components are ignored.  The results are not validated.
+func ShapefileDirParts(fsys FileSystem) ([]string, error) {
+	names, err := globFS(fsys, "*")
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	var parts []string
+	for _, name := range names {
+		if _, ext := shpSplit(name); ext != "" {
+			parts = append(parts, name)
+		}
+	}
+	sort.Strings(parts)
+	return parts, nil
+}
+
+// ValidateShapefileIngest is the default IngestValidator for "shapefile"
+// ingests.  It checks that the data is a zip containing a complete
+// shapefile, and fills in the MIME type of the ingest request.
+func ValidateShapefileIngest(ingData []byte, dRes *DataDesc) error {
+	zipR, err := zip.NewReader(bytes.NewReader(ingData), int64(len(ingData)))
+	if err != nil {
+		return ErrWithTrace("Shapefile ingest is not a valid zip: " + err.Error())
+	}
+	var names []string
+	for _, file := range zipR.File {
+		if !file.FileInfo().IsDir() {
+			names = append(names, file.Name)
+		}
+	}
+	if _, err = CheckShapefileParts(names); err != nil {
+		return TraceErr(err)
+	}
+	dRes.DataType.MimeType = "application/zip"
+	return nil
+}
+
+// IngestShapefile zips the given shapefile components from fsys and ingests
+// the result to Piazza as a shapefile, named for the shared base name of
+// the components.
+func IngestShapefile(fsys FileSystem, fNames []string, pzAddr, sourceName, version, authKey string,
+	props map[string]string) (string, error) {
+
+	stem, err := CheckShapefileParts(fNames)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	zipData, err := ZipShapefile(fsys, fNames)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	return Ingest(stem+".zip", "shapefile", pzAddr, sourceName, version, authKey, zipData, props)
+}
+
+// IngestShapefileDir ingests the single shapefile found in the given
+// directory, as per ShapefileDirParts and IngestShapefile.
+func IngestShapefileDir(dir, pzAddr, sourceName, version, authKey string,
+	props map[string]string) (string, error) {
+
+	fsys := DirFS(dir)
+	parts, err := ShapefileDirParts(fsys)
+	if err != nil {
+		return "", TraceErr(err)
+	}
+	return IngestShapefile(fsys, parts, pzAddr, sourceName, version, authKey, props)
+}
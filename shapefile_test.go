@@ -0,0 +1,68 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"testing"
+)
+
+func TestCheckShapefileParts(t *testing.T) {
+	good := []string{"dir/roads.shp", "dir/roads.SHX", "dir/roads.dbf", "dir/roads.prj", "dir/roads.shp.xml"}
+	if stem, err := CheckShapefileParts(good); err != nil || stem != "roads" {
+		t.Errorf(`TestCheckShapefileParts: failed on good input: %v, "%s"`, err, stem)
+	}
+	badSets := [][]string{
+		{"roads.shp", "roads.shx", "roads.dbf"},
+		{"roads.shp", "roads.shx", "roads.dbf", "rivers.prj"},
+		{"roads.shp", "roads.shx", "roads.dbf", "roads.prj", "roads.txt"},
+		{"roads.shp", "roads.shx", "roads.dbf", "roads.prj", "roads.PRJ"}}
+	for i, bad := range badSets {
+		if _, err := CheckShapefileParts(bad); err == nil {
+			t.Errorf(`TestCheckShapefileParts: passed on bad input %d.`, i)
+		}
+	}
+}
+
+func TestIngestShapefile(t *testing.T) {
+	memFS := NewMemFS()
+	for _, name := range []string{"roads.shp", "roads.shx", "roads.dbf", "roads.prj", "notes.txt"} {
+		memFS.WriteFile(name, []byte(name))
+	}
+	parts, err := ShapefileDirParts(memFS)
+	if err != nil || len(parts) != 4 {
+		t.Fatalf(`TestIngestShapefile: bad parts: %v, %v`, err, parts)
+	}
+
+	zipData, err := ZipShapefile(memFS, parts)
+	if err != nil {
+		t.Fatal(`TestIngestShapefile: zip error: ` + err.Error())
+	}
+	var dRes DataDesc
+	if err = ValidateShapefileIngest(zipData, &dRes); err != nil || dRes.DataType.MimeType != "application/zip" {
+		t.Errorf(`TestIngestShapefile: zip did not validate: %v, %#v`, err, dRes.DataType)
+	}
+	if err = ValidateShapefileIngest([]byte("roads.shp"), &dRes); err == nil {
+		t.Error(`TestIngestShapefile: passed on non-zip.`)
+	}
+
+	outStrs := []string{
+		`{"Data":{"JobID":"testID1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"testData1"}}}`}
+	SetMockClient(outStrs, 250)
+	dataID, err := IngestShapefile(memFS, parts, "http://testURL.net", "tester", "0.0", "testAuthKey", nil)
+	if err != nil || dataID != "testData1" {
+		t.Errorf(`TestIngestShapefile: bad ingest: %v, "%s"`, err, dataID)
+	}
+}
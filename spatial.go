@@ -0,0 +1,184 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Throughout this file, an EpsgCode of 0 is taken to mean EPSG:4326, as
+// that is what Pz assumes when it has nothing better to go on.
+
+const (
+	earthRadius  = 6378137.0 // WGS84 semi-major axis, in meters
+	maxMercLat   = 85.0511287798066
+	maxMercCoord = math.Pi * earthRadius
+)
+
+// normEpsg maps the various aliases of the projections we know to their
+// canonical codes.
+func normEpsg(code int) int {
+	switch code {
+	case 0:
+		return 4326
+	case 900913, 3785, 102100, 102113:
+		return 3857
+	}
+	return code
+}
+
+// BBox returns the horizontal extent of the spatial metadata as a GeoJSON
+// bbox array: [minX, minY, maxX, maxY].
+func (s SpatMeta) BBox() []float64 {
+	return []float64{s.MinX, s.MinY, s.MaxX, s.MaxY}
+}
+
+// Polygon returns the horizontal extent of the spatial metadata as a
+// GeoJSON Polygon, wound counterclockwise.
+func (s SpatMeta) Polygon() *Geometry {
+	ring := [][]float64{
+		{s.MinX, s.MinY},
+		{s.MaxX, s.MinY},
+		{s.MaxX, s.MaxY},
+		{s.MinX, s.MaxY},
+		{s.MinX, s.MinY}}
+	return &Geometry{Type: "Polygon", Coordinates: [][][]float64{ring}}
+}
+
+// Intersects reports whether the extents of the two spatial metadata
+// objects overlap.  Touching edges count as overlap.  If the two are in
+// different projections, other is reprojected to match s first.
+func (s SpatMeta) Intersects(other SpatMeta) (bool, error) {
+	o, err := other.Reproject(s.EpsgCode)
+	if err != nil {
+		return false, TraceErr(err)
+	}
+	return s.MinX <= o.MaxX && o.MinX <= s.MaxX &&
+		s.MinY <= o.MaxY && o.MinY <= s.MaxY, nil
+}
+
+// Contains reports whether the extent of s entirely contains that of other.
+// If the two are in different projections, other is reprojected to match s
+// first.
+func (s SpatMeta) Contains(other SpatMeta) (bool, error) {
+	o, err := other.Reproject(s.EpsgCode)
+	if err != nil {
+		return false, TraceErr(err)
+	}
+	return s.MinX <= o.MinX && o.MaxX <= s.MaxX &&
+		s.MinY <= o.MinY && o.MaxY <= s.MaxY, nil
+}
+
+// Reproject returns a copy of the spatial metadata with its extent converted
+// to the given projection.  Only EPSG:4326 (geographic WGS84) and EPSG:3857
+// (web mercator) are supported.  Z values and feature counts are unchanged.
+func (s SpatMeta) Reproject(epsgCode int) (*SpatMeta, error) {
+	from, to := normEpsg(s.EpsgCode), normEpsg(epsgCode)
+	out := s
+	if from == to {
+		return &out, nil
+	}
+	out.EpsgCode = to
+	out.CoordRefSystem = "EPSG:" + strconv.Itoa(to)
+	switch {
+	case from == 4326 && to == 3857:
+		out.MinX, out.MinY = LonLatToWebMerc(s.MinX, s.MinY)
+		out.MaxX, out.MaxY = LonLatToWebMerc(s.MaxX, s.MaxY)
+	case from == 3857 && to == 4326:
+		out.MinX, out.MinY = WebMercToLonLat(s.MinX, s.MinY)
+		out.MaxX, out.MaxY = WebMercToLonLat(s.MaxX, s.MaxY)
+	default:
+		return nil, ErrWithTrace("Cannot reproject from EPSG:" + strconv.Itoa(from) + " to EPSG:" + strconv.Itoa(to) + ".")
+	}
+	return &out, nil
+}
+
+// LonLatToWebMerc converts a longitude/latitude pair in degrees to web
+// mercator (EPSG:3857) meters.  Latitudes beyond the limits of the
+// projection are clamped.
+func LonLatToWebMerc(lon, lat float64) (float64, float64) {
+	lat = math.Max(-maxMercLat, math.Min(maxMercLat, lat))
+	x := lon * math.Pi / 180 * earthRadius
+	y := math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * earthRadius
+	return x, y
+}
+
+// WebMercToLonLat converts web mercator (EPSG:3857) meters to a
+// longitude/latitude pair in degrees.
+func WebMercToLonLat(x, y float64) (float64, float64) {
+	x = math.Max(-maxMercCoord, math.Min(maxMercCoord, x))
+	lon := x / earthRadius * 180 / math.Pi
+	lat := (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
+
+// searchMaxPages is the most pages SearchDataByExtent will read.
+var searchMaxPages = 1000
+
+// SearchDataByExtent pages through the Pz data resources matching the given
+// keyword (which may be empty), and returns those whose spatial metadata
+// intersects the given extent.  Resources without spatial metadata, or in
+// projections that cannot be compared, are skipped.  Paging stops early if
+// a page repeats the previous one, as from a gateway that ignores the page
+// parameter, and fails if it runs past searchMaxPages.
+func SearchDataByExtent(extent SpatMeta, keyword, pzAddr, authKey string) ([]DataDesc, error) {
+
+	const perPage = 100
+	var (
+		results []DataDesc
+		lastIDs string
+	)
+
+	for page := 0; ; page++ {
+		if page >= searchMaxPages {
+			return nil, ErrWithTrace("Data search ran past " + strconv.Itoa(searchMaxPages) + " pages.")
+		}
+		query := pzAddr + "/data?perPage=" + strconv.Itoa(perPage) + "&page=" + strconv.Itoa(page)
+		if keyword != "" {
+			query += "&keyword=" + url.QueryEscape(keyword)
+		}
+		var respObj FileDataList
+		if _, err := RequestKnownJSON("GET", "", query, authKey, &respObj); err != nil {
+			return nil, TraceErr(err)
+		}
+		ids := make([]string, len(respObj.Data))
+		for i, desc := range respObj.Data {
+			ids[i] = desc.DataID
+		}
+		pageIDs := strings.Join(ids, ",")
+		if page > 0 && pageIDs == lastIDs {
+			break
+		}
+		lastIDs = pageIDs
+		for _, desc := range respObj.Data {
+			if desc.SpatMeta == nil {
+				continue
+			}
+			if hit, err := extent.Intersects(*desc.SpatMeta); err == nil && hit {
+				results = append(results, desc)
+			}
+		}
+		// the count is optional, so a short page is the one sure sign of the end
+		count := respObj.Pagination.Count
+		if len(respObj.Data) < perPage || (count > 0 && (page+1)*perPage >= count) {
+			break
+		}
+	}
+
+	return results, nil
+}
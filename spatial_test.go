@@ -0,0 +1,108 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSpatMetaGeometry(t *testing.T) {
+	outer := SpatMeta{MinX: -10, MinY: -10, MaxX: 10, MaxY: 10}
+	inner := SpatMeta{MinX: -1, MinY: -1, MaxX: 1, MaxY: 1, EpsgCode: 4326}
+	apart := SpatMeta{MinX: 20, MinY: 20, MaxX: 30, MaxY: 30}
+
+	if hit, err := outer.Intersects(inner); err != nil || !hit {
+		t.Error(`TestSpatMetaGeometry: overlapping extents did not intersect.`)
+	}
+	if hit, _ := outer.Intersects(apart); hit {
+		t.Error(`TestSpatMetaGeometry: separate extents intersected.`)
+	}
+	if in, _ := outer.Contains(inner); !in {
+		t.Error(`TestSpatMetaGeometry: outer extent did not contain inner.`)
+	}
+	if in, _ := inner.Contains(outer); in {
+		t.Error(`TestSpatMetaGeometry: inner extent contained outer.`)
+	}
+
+	merc, err := inner.Reproject(3857)
+	if err != nil {
+		t.Fatal(`TestSpatMetaGeometry: reprojection error: ` + err.Error())
+	}
+	if math.Abs(merc.MaxX-111319.49) > 0.01 || merc.EpsgCode != 3857 {
+		t.Errorf(`TestSpatMetaGeometry: bad mercator reprojection: %#v`, merc)
+	}
+	if in, err := outer.Contains(*merc); err != nil || !in {
+		t.Error(`TestSpatMetaGeometry: containment failed across projections.`)
+	}
+	back, _ := merc.Reproject(4326)
+	if math.Abs(back.MinY+1) > 1e-9 || math.Abs(back.MaxX-1) > 1e-9 {
+		t.Errorf(`TestSpatMetaGeometry: reprojection did not round-trip: %#v`, back)
+	}
+	if _, err = inner.Reproject(32633); err == nil {
+		t.Error(`TestSpatMetaGeometry: passed on unsupported projection.`)
+	}
+
+	poly := inner.Polygon()
+	if ring := poly.Coordinates.([][][]float64)[0]; poly.Type != "Polygon" || len(ring) != 5 {
+		t.Errorf(`TestSpatMetaGeometry: bad polygon: %#v`, poly)
+	}
+}
+
+func TestSearchDataByExtent(t *testing.T) {
+	outStrs := []string{`{"data":[
+		{"dataId":"in", "spatialMetadata":{"minX":0, "minY":0, "maxX":1, "maxY":1}},
+		{"dataId":"out", "spatialMetadata":{"minX":50, "minY":50, "maxX":51, "maxY":51}},
+		{"dataId":"none"}], "pagination":{"count":3}}`}
+	SetMockClient(outStrs, 200)
+
+	results, err := SearchDataByExtent(SpatMeta{MinX: -5, MinY: -5, MaxX: 5, MaxY: 5}, "", "http://testURL.net", "testAuthKey")
+	if err != nil {
+		t.Fatal(`TestSearchDataByExtent: error: ` + err.Error())
+	}
+	if len(results) != 1 || results[0].DataID != "in" {
+		t.Errorf(`TestSearchDataByExtent: bad results: %#v`, results)
+	}
+
+	// two full pages, without a count, then an empty one
+	var pages []string
+	for _, prefix := range []string{"a", "b"} {
+		descs := make([]string, 100)
+		for i := range descs {
+			descs[i] = `{"dataId":"` + prefix + strconv.Itoa(i) + `", "spatialMetadata":{"minX":0, "minY":0, "maxX":1, "maxY":1}}`
+		}
+		pages = append(pages, `{"data":[`+strings.Join(descs, ",")+`]}`)
+	}
+	SetMockClient(append(pages, `{"data":[]}`), 200)
+	results, err = SearchDataByExtent(SpatMeta{MinX: -5, MinY: -5, MaxX: 5, MaxY: 5}, "", "http://testURL.net", "testAuthKey")
+	if err != nil || len(results) != 200 || results[199].DataID != "b99" {
+		t.Errorf(`TestSearchDataByExtent: uncounted pages gave %d results, %v`, len(results), err)
+	}
+
+	// a gateway that ignores the page parameter
+	SetMockClient([]string{pages[0], pages[0], pages[1]}, 200)
+	results, err = SearchDataByExtent(SpatMeta{MinX: -5, MinY: -5, MaxX: 5, MaxY: 5}, "", "http://testURL.net", "testAuthKey")
+	if err != nil || len(results) != 100 {
+		t.Errorf(`TestSearchDataByExtent: repeated pages gave %d results, %v`, len(results), err)
+	}
+	defer func(prev int) { searchMaxPages = prev }(searchMaxPages)
+	searchMaxPages = 2
+	SetMockClient(pages, 200)
+	if _, err = SearchDataByExtent(SpatMeta{MinX: -5, MinY: -5, MaxX: 5, MaxY: 5}, "", "http://testURL.net", "testAuthKey"); err == nil {
+		t.Error(`TestSearchDataByExtent: passed beyond the page limit.`)
+	}
+}
@@ -16,7 +16,6 @@ package pzsvc
 
 import (
 	"bytes"
-	"crypto/tls"
 	//  "fmt"
 	"io"
 	"net/http"
@@ -78,8 +77,11 @@ func (t stringSliceMockTransport) RoundTrip(req *http.Request) (*http.Response,
 		if t.outputs[*t.iter] == "" {
 			*t.iter = *t.iter + 1
 
-			tempTransport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-			return tempTransport.RoundTrip(req)
+			client, err := NewHTTPClient(CurrentTLSOptions())
+			if err != nil {
+				return nil, err
+			}
+			return client.Transport.RoundTrip(req)
 		}
 		response.Body = GetMockReadCloser(t.outputs[*t.iter])
 		*t.iter = *t.iter + 1
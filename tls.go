@@ -0,0 +1,235 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TLSOptions controls how the library's HTTP client verifies the servers it
+// talks to, and how it identifies itself to them.  The zero value verifies
+// servers against the system CA pool and presents no client certificate.
+type TLSOptions struct {
+	// CAFiles and CADirs name PEM files, and directories of PEM files
+	// (*.pem, *.crt, *.cer), holding CA certificates to trust in addition to
+	// the system pool.
+	CAFiles []string
+	CADirs  []string
+	// PinnedSHA256 lists the hex SHA-256 fingerprints of DER certificates,
+	// at least one of which must appear in the server's verified chain.
+	// Colons are allowed, and case is ignored.
+	PinnedSHA256 []string
+	// ClientCertFile and ClientKeyFile name the PEM certificate and key to
+	// present to servers that ask for one.
+	ClientCertFile string
+	ClientKeyFile  string
+	// Insecure disables verification of the server's certificate chain and
+	// host name.  Pins are still checked, but only against the server's own
+	// certificate, as there is no verified chain.  Not for production use.
+	Insecure bool
+}
+
+var (
+	tlsOpts TLSOptions
+	tlsLock sync.RWMutex
+)
+
+// HasClientCert reports whether the options include a client certificate.
+func (opts TLSOptions) HasClientCert() bool {
+	return opts.ClientCertFile != ""
+}
+
+// Config builds the tls.Config described by the options.
+func (opts TLSOptions) Config() (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(opts.CAFiles) != 0 || len(opts.CADirs) != 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, fName := range opts.CAFiles {
+			if err := addCAFile(pool, fName); err != nil {
+				return nil, TraceErr(err)
+			}
+		}
+		for _, dir := range opts.CADirs {
+			if err := addCADir(pool, dir); err != nil {
+				return nil, TraceErr(err)
+			}
+		}
+		conf.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, ErrWithTrace("Client certificate and key must be given together.")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, ErrWithTrace("Could not load client certificate: " + err.Error())
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.PinnedSHA256) != 0 {
+		pins := make(map[string]bool)
+		for _, pin := range opts.PinnedSHA256 {
+			pin = strings.ToLower(strings.Replace(pin, ":", "", -1))
+			if byts, err := hex.DecodeString(pin); err != nil || len(byts) != sha256.Size {
+				return nil, ErrWithTrace(`Invalid SHA-256 certificate pin "` + pin + `".`)
+			}
+			pins[pin] = true
+		}
+		// VerifyConnection, unlike VerifyPeerCertificate, also runs on
+		// resumed sessions.
+		conf.VerifyConnection = func(state tls.ConnectionState) error {
+			return checkPins(pins, state, opts.Insecure)
+		}
+	}
+
+	if opts.Insecure {
+		fmt.Println(TraceStr("WARNING: TLS certificate verification is disabled.  Connections are open to interception."))
+		conf.InsecureSkipVerify = true
+	}
+
+	return conf, nil
+}
+
+// checkPins checks the server's certificates against the pinned
+// fingerprints.  Only the verified chains count, as anyone can send a copy
+// of a pinned certificate along with their own.  Without verification,
+// only the server's own certificate can be trusted to be the server's.
+func checkPins(pins map[string]bool, state tls.ConnectionState, insecure bool) error {
+	var candidates []*x509.Certificate
+	if insecure {
+		if len(state.PeerCertificates) != 0 {
+			candidates = state.PeerCertificates[:1]
+		}
+	} else {
+		for _, chain := range state.VerifiedChains {
+			candidates = append(candidates, chain...)
+		}
+	}
+	for _, cert := range candidates {
+		sum := sha256.Sum256(cert.Raw)
+		if pins[hex.EncodeToString(sum[:])] {
+			return nil
+		}
+	}
+	return fmt.Errorf("no certificate presented by %s matches a pinned fingerprint", state.ServerName)
+}
+
+func addCAFile(pool *x509.CertPool, fName string) error {
+	byts, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return ErrWithTrace("Could not read CA file: " + err.Error())
+	}
+	if !pool.AppendCertsFromPEM(byts) {
+		return ErrWithTrace(`No PEM certificates found in CA file "` + fName + `".`)
+	}
+	return nil
+}
+
+func addCADir(pool *x509.CertPool, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ErrWithTrace("Could not read CA directory: " + err.Error())
+	}
+	found := false
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".pem", ".crt", ".cer":
+		default:
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+		byts, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return ErrWithTrace("Could not read CA file: " + err.Error())
+		}
+		found = pool.AppendCertsFromPEM(byts) || found
+	}
+	if !found {
+		return ErrWithTrace(`No PEM certificates found in CA directory "` + dir + `".`)
+	}
+	return nil
+}
+
+// NewHTTPClient builds an http.Client using the given TLS options.
+func NewHTTPClient(opts TLSOptions) (*http.Client, error) {
+	conf, err := opts.Config()
+	if err != nil {
+		return nil, TraceErr(err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+	return &http.Client{Transport: transport}, nil
+}
+
+// SetTLSOptions replaces the current http client (as per HTTPClient) with one
+// using the given TLS options, and records the options for later calls to
+// HTTPClient and CheckClientCert.  If the current client authenticates
+// through an AuthTransport (as from NewAuthClient), the new one does too,
+// with the same Authenticator, so the two may be set in either order.
+func SetTLSOptions(opts TLSOptions) error {
+	client, err := NewHTTPClient(opts)
+	if err != nil {
+		return TraceErr(err)
+	}
+	if current := httpClient; current != nil {
+		if auth, ok := current.Transport.(*AuthTransport); ok {
+			client = NewAuthClient(auth.Auth, client)
+		}
+	}
+	tlsLock.Lock()
+	tlsOpts = opts
+	tlsLock.Unlock()
+	SetHTTPClient(client)
+	return nil
+}
+
+// CurrentTLSOptions returns the options last set through SetTLSOptions.
+func CurrentTLSOptions() TLSOptions {
+	tlsLock.RLock()
+	defer tlsLock.RUnlock()
+	return tlsOpts
+}
+
+// CheckClientCert returns an error if the given resource requires a client
+// certificate and the current TLS options do not provide one.  It is for
+// callers about to contact a resource directly - executing a service at its
+// URL, or downloading from a URL found in its metadata - and should be
+// called with the metadata of that resource first.  The library's own calls
+// all go through the Pz gateway, whose TLS requirements do not depend on
+// the resource, and so do not call it.
+func CheckClientCert(rMeta ResMeta) error {
+	if rMeta.CliCertReq && !CurrentTLSOptions().HasClientCert() {
+		return ErrWithTrace(`Resource "` + rMeta.Name + `" requires a client certificate, and none is configured.`)
+	}
+	return nil
+}
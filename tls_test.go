@@ -0,0 +1,190 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tlsGet(t *testing.T, opts TLSOptions, url string) error {
+	client, err := NewHTTPClient(opts)
+	if err != nil {
+		t.Fatal(`NewHTTPClient failed: ` + err.Error())
+	}
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+// newTestCert generates a self-signed certificate, returned in DER form.
+func newTestCert(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage}}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+// writeClientCert generates a self-signed client certificate and key, and
+// writes them as PEM files in the given directory.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	der, key := newTestCert(t, "test client", x509.ExtKeyUsageClientAuth)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLSOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	pin := hex.EncodeToString(sum[:])
+
+	if err := tlsGet(t, TLSOptions{}, srv.URL); err == nil {
+		t.Error(`TestTLSOptions: unknown CA accepted by default.`)
+	}
+	if err := tlsGet(t, TLSOptions{CAFiles: []string{caFile}}, srv.URL); err != nil {
+		t.Error(`TestTLSOptions: CA file not trusted: ` + err.Error())
+	}
+	if err := tlsGet(t, TLSOptions{CADirs: []string{dir}}, srv.URL); err != nil {
+		t.Error(`TestTLSOptions: CA directory not trusted: ` + err.Error())
+	}
+	if err := tlsGet(t, TLSOptions{CAFiles: []string{caFile}, PinnedSHA256: []string{pin}}, srv.URL); err != nil {
+		t.Error(`TestTLSOptions: matching pin rejected: ` + err.Error())
+	}
+	badPin := "00" + pin[2:]
+	if err := tlsGet(t, TLSOptions{Insecure: true, PinnedSHA256: []string{badPin}}, srv.URL); err == nil {
+		t.Error(`TestTLSOptions: mismatched pin accepted.`)
+	}
+	if err := tlsGet(t, TLSOptions{Insecure: true}, srv.URL); err != nil {
+		t.Error(`TestTLSOptions: insecure mode failed: ` + err.Error())
+	}
+
+	// a server that sends a copy of the pinned certificate after its own
+	pinnedDer, _ := newTestCert(t, "pinned", x509.ExtKeyUsageServerAuth)
+	pinnedSum := sha256.Sum256(pinnedDer)
+	pinnedPin := hex.EncodeToString(pinnedSum[:])
+	spoof := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	spoof.StartTLS()
+	defer spoof.Close()
+	spoof.TLS.Certificates[0].Certificate = append(spoof.TLS.Certificates[0].Certificate, pinnedDer)
+	spoofFile := filepath.Join(t.TempDir(), "spoof.pem")
+	ioutil.WriteFile(spoofFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: spoof.Certificate().Raw}), 0600)
+	if err := tlsGet(t, TLSOptions{CAFiles: []string{spoofFile}, PinnedSHA256: []string{pinnedPin}}, spoof.URL); err == nil {
+		t.Error(`TestTLSOptions: pin matched an unverified certificate.`)
+	}
+	if err := tlsGet(t, TLSOptions{Insecure: true, PinnedSHA256: []string{pinnedPin}}, spoof.URL); err == nil {
+		t.Error(`TestTLSOptions: insecure pin matched a certificate other than the server's own.`)
+	}
+
+	if _, err := (TLSOptions{PinnedSHA256: []string{"abc"}}).Config(); err == nil {
+		t.Error(`TestTLSOptions: passed on malformed pin.`)
+	}
+	if _, err := (TLSOptions{CAFiles: []string{filepath.Join(dir, "missing.pem")}}).Config(); err == nil {
+		t.Error(`TestTLSOptions: passed on missing CA file.`)
+	}
+	if _, err := (TLSOptions{ClientCertFile: caFile}).Config(); err == nil {
+		t.Error(`TestTLSOptions: passed on client cert without key.`)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	certFile, keyFile := writeClientCert(t, t.TempDir())
+	if err := tlsGet(t, TLSOptions{Insecure: true}, srv.URL); err == nil {
+		t.Error(`TestClientCert: server accepted missing client cert.`)
+	}
+	opts := TLSOptions{Insecure: true, ClientCertFile: certFile, ClientKeyFile: keyFile}
+	if err := tlsGet(t, opts, srv.URL); err != nil {
+		t.Error(`TestClientCert: client cert not presented: ` + err.Error())
+	}
+
+	prevClient := HTTPClient()
+	defer func() {
+		SetTLSOptions(TLSOptions{})
+		SetHTTPClient(prevClient)
+	}()
+	rMeta := ResMeta{Name: "svc", CliCertReq: true}
+	if err := SetTLSOptions(TLSOptions{}); err != nil || CheckClientCert(rMeta) == nil {
+		t.Error(`TestClientCert: CheckClientCert passed without a client cert.`)
+	}
+	if err := SetTLSOptions(opts); err != nil || CheckClientCert(rMeta) != nil {
+		t.Error(`TestClientCert: CheckClientCert failed with a client cert.`)
+	}
+}
+
+func TestTLSOptionsKeepAuth(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	prevClient := HTTPClient()
+	defer func() {
+		SetTLSOptions(TLSOptions{})
+		SetHTTPClient(prevClient)
+	}()
+	SetHTTPClient(NewAuthClient(APIKeyAuth("testKey"), nil))
+	if err := SetTLSOptions(TLSOptions{Insecure: true}); err != nil {
+		t.Fatal(`TestTLSOptionsKeepAuth: ` + err.Error())
+	}
+	if _, err := SubmitSinglePart("GET", "", srv.URL, ""); err != nil {
+		t.Fatal(`TestTLSOptionsKeepAuth: call failed: ` + err.Error())
+	}
+	if expected, _ := APIKeyAuth("testKey").AuthHeader(); gotAuth != expected {
+		t.Errorf(`TestTLSOptionsKeepAuth: sent "%s", expected "%s".`, gotAuth, expected)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Calls are correlated across services through W3C Trace Context
+(https://www.w3.org/TR/trace-context/).  The Tracing middleware picks the
+traceparent header up from incoming requests (or starts a new trace), and
+every call the library makes to Pz carries a traceparent header for a new
+span within the trace of its context.  The Context variants of the request
+functions take that context; the plain ones start a new trace for each call.
+Jobs are traced from submission to completion, with their status polls as
+child spans.  Spans are reported to a SpanHook, which by default discards
+them.  When the caller's context carries a trace (or a request ID), errors
+from calls to Pz carry its ID as a correlation ID, as do error bodies
+written for traced requests.  Traces the library starts on its own are not
+reported that way, as the caller has no means of knowing their IDs.
+*/
+
+// TraceparentHeader and TracestateHeader are the W3C Trace Context headers.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// TraceContext identifies a span within a trace.
+type TraceContext struct {
+	TraceID string // 32 lowercase hex digits
+	SpanID  string // 16 lowercase hex digits
+	Sampled bool
+	State   string // the tracestate header, passed along untouched
+}
+
+type traceKey struct{}
+
+// untracedKey marks a context whose trace was started by the library
+// rather than handed to it.
+type untracedKey struct{}
+
+// NewTraceContext starts a new, sampled trace.
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Sampled: true}
+}
+
+// ParseTraceparent parses traceparent and tracestate header values.  Header
+// values from versions later than 00 are accepted as long as they begin
+// with the fields of version 00.
+func ParseTraceparent(traceparent, tracestate string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, ErrWithTrace(`Malformed traceparent "` + traceparent + `".`)
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return TraceContext{}, ErrWithTrace(`Unsupported traceparent version in "` + traceparent + `".`)
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) ||
+		!isLowerHex(spanID, 16) || spanID == strings.Repeat("0", 16) ||
+		!isLowerHex(flags, 2) {
+		return TraceContext{}, ErrWithTrace(`Malformed traceparent "` + traceparent + `".`)
+	}
+	flagBits, _ := strconv.ParseUint(flags, 16, 8)
+	return TraceContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: flagBits&1 == 1,
+		State:   strings.TrimSpace(tracestate)}, nil
+}
+
+// IsValid returns true if the TraceContext has a trace ID and a span ID.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != "" && tc.SpanID != ""
+}
+
+// Traceparent returns the traceparent header value for the TraceContext.
+func (tc TraceContext) Traceparent() string {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + flags
+}
+
+// ContextWithTrace returns a copy of the context carrying the given
+// TraceContext.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	ctx = context.WithValue(ctx, untracedKey{}, false)
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceFromContext returns the TraceContext carried by the context, if any.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok && tc.IsValid()
+}
+
+// InjectTrace sets the trace headers for the TraceContext carried by the
+// context, if any.  It is for calls not made through this library.
+func InjectTrace(ctx context.Context, header http.Header) {
+	if tc, ok := TraceFromContext(ctx); ok {
+		header.Set(TraceparentHeader, tc.Traceparent())
+		if tc.State != "" {
+			header.Set(TracestateHeader, tc.State)
+		}
+	}
+}
+
+// CorrelationID returns an ID that ties together everything done on behalf
+// of the context: the trace ID if it is traced, the request ID set by
+// RequestIDs if not, and the empty string if neither.  Traces started by
+// the library itself, because the context had none, do not count.
+func CorrelationID(ctx context.Context) string {
+	if tc, ok := TraceFromContext(ctx); ok {
+		if untraced, _ := ctx.Value(untracedKey{}).(bool); !untraced {
+			return tc.TraceID
+		}
+	}
+	return RequestID(ctx)
+}
+
+// withCorrelation appends the correlation ID of the context to the error
+// message, if there is one.
+func withCorrelation(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if corrID := CorrelationID(ctx); corrID != "" {
+		return ErrWithTrace(err.Error() + "  Correlation ID: " + corrID)
+	}
+	return err
+}
+
+// Span is a timed operation within a trace.
+type Span struct {
+	Name       string
+	Trace      TraceContext // Trace.SpanID identifies this span
+	ParentID   string       // empty for the root span of a trace
+	Start      time.Time
+	End        time.Time
+	Err        error
+	lock       sync.Mutex
+	attributes map[string]string
+	ended      bool
+}
+
+// SpanHook receives spans as they start and end.  Implementations must be
+// safe for concurrent use, and must not modify the spans.
+type SpanHook interface {
+	SpanStarted(span *Span)
+	SpanEnded(span *Span)
+}
+
+type nopSpans struct{}
+
+func (nopSpans) SpanStarted(*Span) {}
+func (nopSpans) SpanEnded(*Span)   {}
+
+var (
+	spanHook SpanHook = nopSpans{}
+	spanLock sync.RWMutex
+)
+
+// SetSpanHook sets the SpanHook that spans are reported to.  Nil turns
+// reporting off.
+func SetSpanHook(hook SpanHook) {
+	if hook == nil {
+		hook = nopSpans{}
+	}
+	spanLock.Lock()
+	defer spanLock.Unlock()
+	spanHook = hook
+}
+
+func spans() SpanHook {
+	spanLock.RLock()
+	defer spanLock.RUnlock()
+	return spanHook
+}
+
+// StartSpan starts a span as a child of the span carried by the context, or
+// as the root of a new trace if there is none.  It returns a copy of the
+// context carrying the new span, which must be ended with Finish.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{Name: name, Start: time.Now(), attributes: make(map[string]string)}
+	if parent, ok := TraceFromContext(ctx); ok {
+		span.Trace = parent
+		span.Trace.SpanID = randomHex(8)
+		span.ParentID = parent.SpanID
+	} else {
+		span.Trace = NewTraceContext()
+	}
+	spans().SpanStarted(span)
+	return context.WithValue(ctx, traceKey{}, span.Trace), span
+}
+
+// startSpan is StartSpan for the library's own spans.  If the context has
+// no trace, the new one is marked as the library's own, so that its ID is
+// not used as a correlation ID.
+func startSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if _, ok := TraceFromContext(ctx); !ok {
+		ctx = context.WithValue(ctx, untracedKey{}, true)
+	}
+	return StartSpan(ctx, name)
+}
+
+// SetAttr records a key/value attribute on the span.
+func (s *Span) SetAttr(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the attributes recorded on the span.
+func (s *Span) Attributes() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	attrs := make(map[string]string, len(s.attributes))
+	for key, val := range s.attributes {
+		attrs[key] = val
+	}
+	return attrs
+}
+
+// Finish ends the span, recording the error it ended with, if any.  Only
+// the first call has any effect.
+func (s *Span) Finish(err error) {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.Err = err
+	s.lock.Unlock()
+	spans().SpanEnded(s)
+}
+
+// Tracing is middleware that continues the trace in the traceparent header
+// of each request, or starts a new one if it has none (or an invalid one),
+// and runs the rest of the request within a server span.  Handlers can
+// pass the request context to the Context request functions to carry the
+// trace on to Pz.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if tc, err := ParseTraceparent(r.Header.Get(TraceparentHeader), r.Header.Get(TracestateHeader)); err == nil {
+			ctx = ContextWithTrace(ctx, tc)
+		}
+		ctx, span := StartSpan(ctx, r.Method+" "+r.URL.Path)
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			span.SetAttr("http.status_code", strconv.Itoa(rec.status))
+			var err error
+			if rec.status >= 500 {
+				err = ErrWithTrace(http.StatusText(rec.status))
+			}
+			span.Finish(err)
+		}()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// doTraced sends the request through the client within a client span,
+// carrying the span in the trace headers and reporting the call to the
+// MetricsHook.
+func doTraced(ctx context.Context, client *http.Client, req *http.Request, upload int64) (*http.Response, error) {
+	endpoint := metricEndpoint(req.URL.String())
+	ctx, span := startSpan(ctx, "HTTP "+req.Method+" "+endpoint)
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", endpoint)
+	InjectTrace(ctx, req.Header)
+
+	resp, err := doMetered(client, req, upload)
+	if resp != nil {
+		span.SetAttr("http.status_code", strconv.Itoa(resp.StatusCode))
+		if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			span.Finish(ErrWithTrace(resp.Status))
+			return resp, nil
+		}
+	}
+	span.Finish(err)
+	return resp, err
+}
+
+func isLowerHex(str string, length int) bool {
+	if len(str) != length {
+		return false
+	}
+	for _, char := range str {
+		if (char < '0' || char > '9') && (char < 'a' || char > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes as hex.  All zeros is an invalid ID, and
+// so is never returned.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	for {
+		rand.Read(b)
+		for _, byt := range b {
+			if byt != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
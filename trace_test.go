@@ -0,0 +1,231 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanRecorder struct {
+	lock  sync.Mutex
+	ended []*Span
+}
+
+func (sr *spanRecorder) SpanStarted(*Span) {}
+
+func (sr *spanRecorder) SpanEnded(span *Span) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.ended = append(sr.ended, span)
+}
+
+func (sr *spanRecorder) named(prefix string) []*Span {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	var found []*Span
+	for _, span := range sr.ended {
+		if strings.HasPrefix(span.Name, prefix) {
+			found = append(found, span)
+		}
+	}
+	return found
+}
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestParseTraceparent(t *testing.T) {
+	tc, err := ParseTraceparent("00-"+testTraceID+"-00f067aa0ba902b7-01", "congo=t61rcWkgMzE")
+	if err != nil {
+		t.Fatal(`TestParseTraceparent: valid header rejected: ` + err.Error())
+	}
+	if tc.TraceID != testTraceID || tc.SpanID != "00f067aa0ba902b7" || !tc.Sampled || tc.State != "congo=t61rcWkgMzE" {
+		t.Errorf(`TestParseTraceparent: bad parse: %#v`, tc)
+	}
+	if out := tc.Traceparent(); out != "00-"+testTraceID+"-00f067aa0ba902b7-01" {
+		t.Errorf(`TestParseTraceparent: bad round trip: %s`, out)
+	}
+	if tc, err = ParseTraceparent("01-"+testTraceID+"-00f067aa0ba902b7-00-extra", ""); err != nil || tc.Sampled {
+		t.Errorf(`TestParseTraceparent: later version gave %#v, %v`, tc, err)
+	}
+
+	badHeaders := []string{
+		"",
+		"00-" + testTraceID + "-00f067aa0ba902b7",
+		"00-" + testTraceID + "-00f067aa0ba902b7-01-extra",
+		"ff-" + testTraceID + "-00f067aa0ba902b7-01",
+		"00-" + strings.ToUpper(testTraceID) + "-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-" + testTraceID + "-0000000000000000-01",
+		"00-" + testTraceID + "-00f067aa0ba902-01",
+	}
+	for _, header := range badHeaders {
+		if _, err = ParseTraceparent(header, ""); err == nil {
+			t.Errorf(`TestParseTraceparent: "%s" accepted.`, header)
+		}
+	}
+
+	if tc = NewTraceContext(); !tc.IsValid() || len(tc.TraceID) != 32 || len(tc.SpanID) != 16 {
+		t.Errorf(`TestParseTraceparent: bad new trace: %#v`, tc)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	SetSpanHook(recorder)
+	defer SetSpanHook(nil)
+	prev := HTTPClient()
+	defer SetHTTPClient(prev)
+
+	var outgoing []string
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		outgoing = append(outgoing, req.Header.Get(TraceparentHeader)+" "+req.Header.Get(TracestateHeader))
+		return &http.Response{
+			StatusCode: http.StatusBadGateway,
+			Status:     "502 Bad Gateway",
+			Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+			Header:     make(http.Header)}, nil
+	})})
+
+	var callErr error
+	rt := NewRouter(Tracing)
+	rt.HandleFunc("/call", func(w http.ResponseWriter, r *http.Request) error {
+		var outObj map[string]interface{}
+		_, callErr = RequestKnownJSONContext(r.Context(), "GET", "", "http://testURL.net/data/1234", "testAuthKey", &outObj)
+		return &HTTPError{Status: http.StatusBadGateway, Message: "upstream failed"}
+	})
+	rec := serveTest(rt, "GET", "/call", "", map[string]string{
+		TraceparentHeader: "00-" + testTraceID + "-00f067aa0ba902b7-01",
+		TracestateHeader:  "congo=t61rcWkgMzE"})
+
+	var outErr Error
+	if err := json.Unmarshal(rec.Body.Bytes(), &outErr); err != nil || outErr.CorrelationID != testTraceID {
+		t.Errorf(`TestTracing: bad error body: %s`, rec.Body.String())
+	}
+	if callErr == nil || !strings.Contains(callErr.Error(), "Correlation ID: "+testTraceID) {
+		t.Errorf(`TestTracing: call error lacks correlation ID: %v`, callErr)
+	}
+
+	server, client := recorder.named("GET /call"), recorder.named("HTTP GET /data/{id}")
+	if len(server) != 1 || len(client) != 1 {
+		t.Fatalf(`TestTracing: expected a server span and a client span, got %d and %d.`, len(server), len(client))
+	}
+	if server[0].ParentID != "00f067aa0ba902b7" || server[0].Trace.TraceID != testTraceID ||
+		server[0].Attributes()["http.status_code"] != "502" || server[0].Err == nil {
+		t.Errorf(`TestTracing: bad server span: %#v`, server[0])
+	}
+	if client[0].ParentID != server[0].Trace.SpanID || client[0].Err == nil {
+		t.Errorf(`TestTracing: bad client span: %#v`, client[0])
+	}
+	expected := "00-" + testTraceID + "-" + client[0].Trace.SpanID + "-01 congo=t61rcWkgMzE"
+	if len(outgoing) != 1 || outgoing[0] != expected {
+		t.Errorf(`TestTracing: sent %v, expected "%s".`, outgoing, expected)
+	}
+
+	outgoing = nil
+	SubmitSinglePart("GET", "", "http://testURL.net/data", "testAuthKey")
+	if len(outgoing) != 1 || strings.Contains(outgoing[0], testTraceID) || !strings.HasPrefix(outgoing[0], "00-") {
+		t.Errorf(`TestTracing: untraced call sent %v.`, outgoing)
+	}
+}
+
+func TestJobTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	SetSpanHook(recorder)
+	defer SetSpanHook(nil)
+	SetDefaultJobWatcher(NewJobWatcher(0, time.Millisecond))
+	defer SetDefaultJobWatcher(nil)
+
+	parent := TraceContext{TraceID: testTraceID, SpanID: "00f067aa0ba902b7", Sampled: true}
+	ctx, cancel := context.WithCancel(ContextWithTrace(context.Background(), parent))
+	SetMockClient([]string{
+		`{"data":{"status":"Running"}}`,
+		`{"data":{"status":"Error", "jobType":"ingest", "result":{"type":"error", "message":"bad"}}}`}, 200)
+	job := NewJobContext(ctx, "job1", "http://testURL.net", "testAuthKey")
+	cancel()
+	_, err := job.Wait(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Correlation ID: "+testTraceID) {
+		t.Errorf(`TestJobTracing: job error lacks correlation ID: %v`, err)
+	}
+
+	jobSpans, polls := recorder.named("pz.job"), recorder.named("HTTP GET /job/{id}")
+	if len(jobSpans) != 1 || len(polls) != 2 {
+		t.Fatalf(`TestJobTracing: expected a job span and two polls, got %d and %d.`, len(jobSpans), len(polls))
+	}
+	jobSpan := jobSpans[0]
+	if jobSpan.ParentID != parent.SpanID || jobSpan.Err == nil || jobSpan.Attributes()["pz.job_type"] != "ingest" {
+		t.Errorf(`TestJobTracing: bad job span: %#v`, jobSpan)
+	}
+	for _, poll := range polls {
+		if poll.ParentID != jobSpan.Trace.SpanID || poll.Trace.TraceID != testTraceID {
+			t.Errorf(`TestJobTracing: poll not within job span: %#v`, poll)
+		}
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	SetDefaultJobWatcher(NewJobWatcher(0, time.Millisecond))
+	defer SetDefaultJobWatcher(nil)
+	prev := HTTPClient()
+	defer SetHTTPClient(prev)
+
+	var outgoing []string
+	outputs := []string{
+		`{"Data":{"JobID":"job1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"data1"}}}`}
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		outgoing = append(outgoing, req.Header.Get(TraceparentHeader))
+		status, body := http.StatusBadGateway, `{}`
+		if len(outputs) > 0 {
+			status, body, outputs = http.StatusOK, outputs[0], outputs[1:]
+		}
+		return &http.Response{
+			StatusCode: status,
+			Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header)}, nil
+	})})
+
+	parent := TraceContext{TraceID: testTraceID, SpanID: "00f067aa0ba902b7", Sampled: true}
+	dataID, err := IngestContext(ContextWithTrace(context.Background(), parent),
+		"", "text", "http://testURL.net", "tester", "0.0", "testAuthKey", []byte("text"), nil)
+	if err != nil || dataID != "data1" {
+		t.Fatalf(`TestCorrelation: ingest gave "%s", %v`, dataID, err)
+	}
+	if len(outgoing) != 2 || !strings.Contains(outgoing[0], testTraceID) || !strings.Contains(outgoing[1], testTraceID) {
+		t.Errorf(`TestCorrelation: ingest did not carry the trace: %v`, outgoing)
+	}
+
+	if _, err = SubmitSinglePart("GET", "", "http://testURL.net/data", "testAuthKey"); err == nil ||
+		strings.Contains(err.Error(), "Correlation ID") {
+		t.Errorf(`TestCorrelation: untraced call gave %v`, err)
+	}
+	if _, err = NewJob("job2", "http://testURL.net", "testAuthKey").Wait(context.Background()); err == nil ||
+		strings.Contains(err.Error(), "Correlation ID") {
+		t.Errorf(`TestCorrelation: untraced job gave %v`, err)
+	}
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req1")
+	if _, err = SubmitSinglePartContext(ctx, "GET", "", "http://testURL.net/data", "testAuthKey"); err == nil ||
+		!strings.Contains(err.Error(), "Correlation ID: req1") {
+		t.Errorf(`TestCorrelation: call with request ID gave %v`, err)
+	}
+}
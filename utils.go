@@ -24,7 +24,8 @@ import (
 
 // Error is a type designed for easy serialization to JSON
 type Error struct {
-	Message string `json:"error"`
+	Message       string `json:"error"`
+	CorrelationID string `json:"correlationId,omitempty"`
 }
 
 func (err Error) Error() string {
@@ -155,7 +155,7 @@ func GetAlerts(perPage, pageNo, trigID, pzAddr, pzAuth string) ([]Alert, error)
 	var outpObj AlertList
 
 	if _, err := RequestKnownJSON("GET", "", pzAddr+"/alert?"+qParams, pzAuth, &outpObj); err != nil {
-		return nil, fmt.Errorf("Error: pzsvc.RequestKnownJSON: fail on alert check: " + err.Error())
+		return nil, fmt.Errorf("Error: pzsvc.RequestKnownJSON: fail on alert check: %s", err.Error())
 	}
 	return outpObj.Data, nil
 }